@@ -0,0 +1,280 @@
+package fjson2csv
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Functions of aggregate columns (see `Options.Aggregates`).
+const (
+	CountAggregate         string = "count"
+	SumAggregate           string = "sum"
+	MinAggregate           string = "min"
+	MaxAggregate           string = "max"
+	AvgAggregate           string = "avg"
+	CountDistinctAggregate string = "count_distinct"
+)
+
+// An aggregate column, computed from the values of a column in each group of
+// records.
+type Aggregate struct {
+	// Name of the column (default: the function and the column it aggregates,
+	// eg. "sum_score", or "count" when counting records)
+	Name     string
+	Function string
+	// Column whose values are aggregated. Counts without a column count
+	// records, and otherwise count the non-null values of the column.
+	Column string
+}
+
+/*
+ * Aggregation groups the records selected by the filter by the values of
+ * their group columns, in a single pass, and writes a row for each group (in
+ * order of their first record) rather than the records themselves. Rows are
+ * indexed like records, so they are written (and may be sorted or limited)
+ * like any others.
+ */
+type aggregator struct {
+	columns    []string
+	aggregates []Aggregate
+	groups     map[recordHash]*group
+	order      []*group
+}
+
+// The values of a group's columns, and the state of its aggregates.
+type group struct {
+	key    map[string]interface{}
+	states []aggregateState
+}
+
+type aggregateState struct {
+	count    int64
+	numbers  int64
+	sum      float64
+	min      interface{}
+	max      interface{}
+	distinct map[recordHash]bool
+}
+
+func newAggregator(opts Options) (*aggregator, error) {
+	if len(opts.GroupBy) == 0 && len(opts.Aggregates) == 0 {
+		return nil, nil
+	}
+	if opts.Schema != nil {
+		return nil, fmt.Errorf("aggregation is not supported with a schema")
+	}
+	a := &aggregator{columns: opts.GroupBy, groups: map[recordHash]*group{}}
+	names := map[string]bool{}
+	for _, col := range opts.GroupBy {
+		names[col] = true
+	}
+	aggregates := opts.Aggregates
+	if len(aggregates) == 0 {
+		aggregates = []Aggregate{{Function: CountAggregate}}
+	}
+	for _, agg := range aggregates {
+		switch agg.Function {
+		case CountAggregate:
+		case SumAggregate, MinAggregate, MaxAggregate, AvgAggregate, CountDistinctAggregate:
+			if agg.Column == "" {
+				return nil, fmt.Errorf("malformed aggregate: %s requires a column", agg.Function)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported aggregate: %s", agg.Function)
+		}
+		if agg.Name == "" {
+			agg.Name = agg.Function
+			if agg.Column != "" {
+				agg.Name += "_" + agg.Column
+			}
+		}
+		if names[agg.Name] == true {
+			return nil, fmt.Errorf("malformed aggregate: duplicate column '%s'", agg.Name)
+		}
+		names[agg.Name] = true
+		a.aggregates = append(a.aggregates, agg)
+	}
+	return a, nil
+}
+
+// Adds a record to its group.
+func (a *aggregator) add(record map[string]interface{}) {
+	// Without group columns, every record is in the same group
+	var hash recordHash
+	if len(a.columns) > 0 {
+		hash = hashColumns(record, a.columns)
+	}
+	g, ok := a.groups[hash]
+	if ok == false {
+		g = &group{key: map[string]interface{}{}, states: make([]aggregateState, len(a.aggregates))}
+		for _, col := range a.columns {
+			if value, ok := record[col]; ok == true {
+				g.key[col] = value
+			}
+		}
+		a.groups[hash] = g
+		a.order = append(a.order, g)
+	}
+
+	for i, agg := range a.aggregates {
+		state := &g.states[i]
+		if agg.Column == "" {
+			state.count++
+			continue
+		}
+		value := record[agg.Column]
+		if value == nil {
+			continue
+		}
+		state.count++
+		switch agg.Function {
+		case SumAggregate, AvgAggregate:
+			if n, ok := aggregateNumber(value); ok == true {
+				state.sum += n
+				state.numbers++
+			}
+		case MinAggregate, MaxAggregate:
+			value = aggregateScalar(value)
+			if state.min == nil || compareAggregated(value, state.min) < 0 {
+				state.min = value
+			}
+			if state.max == nil || compareAggregated(value, state.max) > 0 {
+				state.max = value
+			}
+		case CountDistinctAggregate:
+			if state.distinct == nil {
+				state.distinct = map[recordHash]bool{}
+			}
+			h := sha256.New()
+			hashValue(h, value)
+			var key recordHash
+			copy(key[:], h.Sum(nil))
+			state.distinct[key] = true
+		}
+	}
+}
+
+// Returns a row for each group, in order of their first record.
+func (a *aggregator) rows() []map[string]interface{} {
+	rows := make([]map[string]interface{}, 0, len(a.order))
+	for _, g := range a.order {
+		row := make(map[string]interface{}, len(g.key)+len(a.aggregates))
+		for key, value := range g.key {
+			row[key] = value
+		}
+		for i, agg := range a.aggregates {
+			state := g.states[i]
+			var value interface{}
+			switch agg.Function {
+			case CountAggregate:
+				value = float64(state.count)
+			case CountDistinctAggregate:
+				value = float64(len(state.distinct))
+			case SumAggregate:
+				if state.numbers > 0 {
+					value = state.sum
+				}
+			case AvgAggregate:
+				if state.numbers > 0 {
+					value = state.sum / float64(state.numbers)
+				}
+			case MinAggregate:
+				value = state.min
+			case MaxAggregate:
+				value = state.max
+			}
+			row[agg.Name] = value
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// Returns the output's columns: the group columns, then the aggregates.
+func (a *aggregator) names() []string {
+	names := append([]string{}, a.columns...)
+	for _, agg := range a.aggregates {
+		names = append(names, agg.Name)
+	}
+	return names
+}
+
+// Reads a number, or a numeric string, summed by an aggregate.
+func aggregateNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case string:
+		n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// Returns a value compared by the min and max aggregates: numbers as they
+// are, and anything else as text.
+func aggregateScalar(value interface{}) interface{} {
+	switch v := value.(type) {
+	case float64, string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return toString(value)
+}
+
+// Compares values of the min and max aggregates. Numbers order before text.
+func compareAggregated(a interface{}, b interface{}) int {
+	if order, ok := compareValues(a, b); ok == true {
+		return order
+	}
+	if _, ok := a.(float64); ok == true {
+		return -1
+	}
+	return 1
+}
+
+// Groups the records selected by the filter (and sampling), leaving a row
+// for each group in the buffer to be written.
+func (c *converter) aggregate() {
+	if c.err != nil {
+		return
+	}
+	c.findDuplicates()
+	if c.dedup != nil && c.err == nil {
+		c.err = c.dedup.prepare()
+	}
+	if c.err != nil {
+		return
+	}
+	c.WalkJsonList(func(record map[string]interface{}, args ...interface{}) error {
+		if c.includes(record) == false {
+			return nil
+		}
+		if c.dedup != nil {
+			if keep, err := c.dedup.keeps(record); err != nil || keep == false {
+				return err
+			}
+		}
+		c.groups.add(record)
+		return nil
+	})
+
+	// Duplicates were already dropped, and the rows are only written once
+	if c.dedup != nil {
+		c.dedup.close()
+		c.dedup = nil
+	}
+	if c.err != nil {
+		return
+	}
+
+	c.buffer = c.groups.rows()
+	for _, row := range c.buffer {
+		c.index(row, true)
+	}
+	c.sorted = c.groups.names()
+	c.resolveConflicts()
+}
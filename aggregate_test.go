@@ -0,0 +1,100 @@
+package fjson2csv
+
+import (
+	"testing"
+)
+
+func TestAggregateConvert(t *testing.T) {
+	t.Parallel()
+
+	raw := `[
+		{"team":"red", "region":"east", "score":10, "user":"ann", "joined":"2024-01-05"},
+		{"team":"blue", "region":"west", "score":7.5, "user":"bob", "joined":"2023-11-20"},
+		{"team":"red", "region":"west", "score":"20", "user":"ann", "joined":"2024-02-01"},
+		{"team":"red", "region":"east", "score":null, "user":"cid", "joined":"2022-06-30"},
+		{"team":"blue", "region":"west", "score":2.5, "user":"dee"},
+		{"region":"east", "score":1, "user":"eve", "joined":"2024-03-03"}
+	]`
+
+	cases := []conversionCase{
+		{
+			"count",
+			Options{GroupBy: []string{"team"}},
+			"team,count\n" +
+				"red,3\n" +
+				"blue,2\n" +
+				",1\n",
+			false,
+		},
+		{
+			"aggregates",
+			Options{
+				GroupBy: []string{"team"},
+				Aggregates: []Aggregate{
+					{Function: CountAggregate, Column: "score"},
+					{Function: SumAggregate, Column: "score"},
+					{Name: "mean", Function: AvgAggregate, Column: "score"},
+					{Function: CountDistinctAggregate, Column: "user"},
+				},
+			},
+			"team,count_score,sum_score,mean,count_distinct_user\n" +
+				"red,2,30,15,2\n" +
+				"blue,2,10,5,2\n" +
+				",1,1,1,1\n",
+			false,
+		},
+		{
+			"min and max",
+			Options{
+				GroupBy: []string{"team"},
+				Aggregates: []Aggregate{
+					{Function: MinAggregate, Column: "joined"},
+					{Function: MaxAggregate, Column: "joined"},
+					{Function: MaxAggregate, Column: "score"},
+				},
+			},
+			"team,min_joined,max_joined,max_score\n" +
+				"red,2022-06-30,2024-02-01,20\n" +
+				"blue,2023-11-20,2023-11-20,7.5\n" +
+				",2024-03-03,2024-03-03,1\n",
+			false,
+		},
+		{
+			"several columns",
+			Options{GroupBy: []string{"team", "region"}, Filter: "team != null"},
+			"team,region,count\n" +
+				"red,east,2\n" +
+				"blue,west,2\n" +
+				"red,west,1\n",
+			false,
+		},
+		{
+			"single group",
+			Options{Aggregates: []Aggregate{{Function: CountAggregate}, {Function: SumAggregate, Column: "score"}}},
+			"count,sum_score\n" +
+				"6,41\n",
+			false,
+		},
+		{
+			"sorted",
+			Options{GroupBy: []string{"region"}, SortBy: []string{"-count", "region"}, Limit: 1},
+			"region,count\n" +
+				"east,3\n",
+			false,
+		},
+		{
+			"deduplicated",
+			Options{GroupBy: []string{"team"}, Dedup: KeepFirst, DedupColumns: []string{"user"}},
+			"team,count\n" +
+				"red,2\n" +
+				"blue,2\n" +
+				",1\n",
+			false,
+		},
+		{"unknown function", Options{Aggregates: []Aggregate{{Function: "median", Column: "score"}}}, "", true},
+		{"missing column", Options{Aggregates: []Aggregate{{Function: SumAggregate}}}, "", true},
+		{"duplicate column", Options{GroupBy: []string{"count"}}, "", true},
+		{"schema", Options{GroupBy: []string{"team"}, Schema: []Column{{Name: "team"}}}, "", true},
+	}
+	checkConversions(t, raw, cases)
+}
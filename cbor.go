@@ -0,0 +1,329 @@
+package fjson2csv
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"time"
+)
+
+// Input format of CBOR records.
+const CborFormat string = "cbor"
+
+// Creates a source reading CBOR records, given either as a sequence of maps
+// (RFC 8742) or as a single array of maps.
+//
+// Values are converted to their JSON equivalents: numbers become float64,
+// byte strings are encoded as text (see `Options.BinaryEncoding`) and epoch
+// timestamps are formatted as RFC 3339. Tags other than timestamps and
+// bignums are ignored in favor of the values they enclose.
+func NewCborSource(r io.ReadSeeker, opts Options) RecordSource {
+	rsize, _ := getBufferSizes(opts)
+	return &binarySource{
+		r:    r,
+		size: rsize,
+		newDecoder: func(br *bufio.Reader) binaryDecoder {
+			return &cborDecoder{r: br, binary: opts.BinaryEncoding}
+		},
+	}
+}
+
+// Major types of CBOR data items.
+const (
+	cbor_unsigned byte = iota
+	cbor_negative
+	cbor_bytes
+	cbor_text
+	cbor_array
+	cbor_map
+	cbor_tag
+	cbor_simple
+)
+
+// Marks the end of an item of indefinite length.
+const cbor_break byte = 0xff
+
+type cborDecoder struct {
+	r      *bufio.Reader
+	binary string
+}
+
+func (d *cborDecoder) readArray() (int, bool, error) {
+	// Skip any self-describe tag (55799), which identifies documents as CBOR
+	if peek, err := d.r.Peek(3); err == nil && peek[0] == 0xd9 && peek[1] == 0xd9 && peek[2] == 0xf7 {
+		d.r.Discard(3)
+	}
+	peek, err := d.r.Peek(1)
+	if err != nil {
+		return 0, false, d.malformed(err)
+	}
+	if peek[0]>>5 != cbor_array {
+		return 0, false, nil
+	}
+	_, n, indefinite, err := d.readHead()
+	if err != nil {
+		return 0, false, err
+	} else if indefinite {
+		return -1, true, nil
+	} else if n > math.MaxInt32 {
+		return 0, false, fmt.Errorf("malformed CBOR: invalid array length %d", n)
+	}
+	return int(n), true, nil
+}
+
+func (d *cborDecoder) readBreak() (bool, error) {
+	peek, err := d.r.Peek(1)
+	if err != nil {
+		return false, d.malformed(err)
+	}
+	if peek[0] != cbor_break {
+		return false, nil
+	}
+	d.r.ReadByte()
+	return true, nil
+}
+
+func (d *cborDecoder) readRecord() (Record, error) {
+	major, n, indefinite, err := d.readHead()
+	for err == nil && major == cbor_tag {
+		major, n, indefinite, err = d.readHead()
+	}
+	if err != nil {
+		return nil, err
+	} else if major != cbor_map {
+		return nil, fmt.Errorf("malformed CBOR: records must be maps")
+	}
+
+	record := Record{}
+	for i := uint64(0); indefinite || i < n; i++ {
+		if indefinite {
+			if end, err := d.readBreak(); err != nil {
+				return nil, err
+			} else if end {
+				break
+			}
+		}
+		key, err := d.readValue(1)
+		if err != nil {
+			return nil, err
+		}
+		value, err := d.readValue(1)
+		if err != nil {
+			return nil, err
+		}
+		record = append(record, Field{mapKey(key), value})
+	}
+	return record, nil
+}
+
+// Reads the next data item, at the given depth of nesting.
+func (d *cborDecoder) readValue(depth int) (interface{}, error) {
+	if depth > binary_max_depth {
+		return nil, fmt.Errorf("malformed CBOR: values nested too deeply")
+	}
+	initial, err := d.r.Peek(1)
+	if err != nil {
+		return nil, d.malformed(err)
+	}
+	info := initial[0] & 0x1f
+	major, n, indefinite, err := d.readHead()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case cbor_unsigned:
+		return float64(n), nil
+	case cbor_negative:
+		return -1 - float64(n), nil
+	case cbor_bytes:
+		data, err := d.readString(major, n, indefinite)
+		if err != nil {
+			return nil, err
+		}
+		return encodeBinary(data, d.binary), nil
+	case cbor_text:
+		data, err := d.readString(major, n, indefinite)
+		if err != nil {
+			return nil, err
+		}
+		return string(data), nil
+	case cbor_array:
+		values := []interface{}{}
+		for i := uint64(0); indefinite || i < n; i++ {
+			if indefinite {
+				if end, err := d.readBreak(); err != nil {
+					return nil, err
+				} else if end {
+					break
+				}
+			}
+			value, err := d.readValue(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	case cbor_map:
+		m := make(map[string]interface{})
+		for i := uint64(0); indefinite || i < n; i++ {
+			if indefinite {
+				if end, err := d.readBreak(); err != nil {
+					return nil, err
+				} else if end {
+					break
+				}
+			}
+			key, err := d.readValue(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if m[mapKey(key)], err = d.readValue(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case cbor_tag:
+		return d.readTagged(n, depth)
+	}
+
+	// Simple values and floats
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 25:
+		return halfFloat(uint16(n)), nil
+	case 26:
+		return float64(math.Float32frombits(uint32(n))), nil
+	case 27:
+		return math.Float64frombits(n), nil
+	case 31:
+		return nil, fmt.Errorf("malformed CBOR: unexpected break")
+	}
+	return float64(n), nil
+}
+
+// Reads the item enclosed by a tag. Epoch timestamps are formatted as
+// RFC 3339, bignums are converted to numbers and any other tag is ignored.
+func (d *cborDecoder) readTagged(tag uint64, depth int) (interface{}, error) {
+	if tag == 1 {
+		value, err := d.readValue(depth + 1)
+		if seconds, ok := value.(float64); ok == true {
+			whole, fraction := math.Modf(seconds)
+			value = time.Unix(int64(whole), int64(fraction*1e9)).UTC().Format(time.RFC3339Nano)
+		}
+		return value, err
+	}
+	if tag != 2 && tag != 3 {
+		return d.readValue(depth + 1)
+	}
+	major, n, indefinite, err := d.readHead()
+	if err != nil {
+		return nil, err
+	} else if major != cbor_bytes {
+		return nil, fmt.Errorf("malformed CBOR: bignums must be byte strings")
+	}
+	data, err := d.readString(major, n, indefinite)
+	if err != nil {
+		return nil, err
+	}
+	value, _ := new(big.Float).SetInt(new(big.Int).SetBytes(data)).Float64()
+	if tag == 3 {
+		value = -1 - value
+	}
+	return value, nil
+}
+
+// Reads the contents of a byte or text string, joining the chunks of strings
+// of indefinite length.
+func (d *cborDecoder) readString(major byte, n uint64, indefinite bool) ([]byte, error) {
+	if indefinite == false {
+		data, err := readBytes(d.r, n)
+		if err != nil {
+			return nil, d.malformed(err)
+		}
+		return data, nil
+	}
+
+	data := []byte{}
+	for {
+		if end, err := d.readBreak(); err != nil {
+			return nil, err
+		} else if end {
+			return data, nil
+		}
+		chunkMajor, n, chunkIndefinite, err := d.readHead()
+		if err != nil {
+			return nil, err
+		} else if chunkMajor != major || chunkIndefinite {
+			return nil, fmt.Errorf("malformed CBOR: invalid string chunk")
+		}
+		chunk, err := readBytes(d.r, n)
+		if err != nil {
+			return nil, d.malformed(err)
+		}
+		data = append(data, chunk...)
+	}
+}
+
+// Reads the head of a data item: its major type and argument, and whether its
+// length is indefinite.
+func (d *cborDecoder) readHead() (byte, uint64, bool, error) {
+	initial, err := d.r.ReadByte()
+	if err != nil {
+		return 0, 0, false, d.malformed(err)
+	}
+	major, info := initial>>5, initial&0x1f
+	switch {
+	case info < 24:
+		return major, uint64(info), false, nil
+	case info <= 27:
+		data, err := readBytes(d.r, uint64(1)<<(info-24))
+		if err != nil {
+			return 0, 0, false, d.malformed(err)
+		}
+		var n uint64
+		for _, b := range data {
+			n = n<<8 | uint64(b)
+		}
+		return major, n, false, nil
+	case info == 31 && major != cbor_unsigned && major != cbor_negative && major != cbor_tag:
+		return major, 0, true, nil
+	}
+	return 0, 0, false, fmt.Errorf("malformed CBOR: invalid item 0x%02x", initial)
+}
+
+func (d *cborDecoder) malformed(err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("malformed CBOR: %s", err.Error())
+}
+
+// Converts an IEEE 754 half-precision float.
+func halfFloat(bits uint16) float64 {
+	exponent, mantissa := int(bits>>10)&0x1f, int(bits&0x3ff)
+	var value float64
+	switch exponent {
+	case 0:
+		value = math.Ldexp(float64(mantissa), -24)
+	case 31:
+		value = math.Inf(1)
+		if mantissa != 0 {
+			value = math.NaN()
+		}
+	default:
+		value = math.Ldexp(float64(mantissa+1024), exponent-25)
+	}
+	if bits&0x8000 != 0 {
+		value = -value
+	}
+	return value
+}
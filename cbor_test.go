@@ -0,0 +1,81 @@
+package fjson2csv
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCborSource(t *testing.T) {
+	t.Parallel()
+
+	records := []string{
+		// {"a":1, "b":"x", "c":h'0102ff'}
+		"a3 61 61 01 61 62 61 78 61 63 43 01 02 ff",
+		// {"d":-2, "e":null, "t":1(0)}
+		"a3 61 64 21 61 65 f6 61 74 c1 00",
+		// {_ "f":1.5 (half precision), "g":-1000, "h":[_ 1, {"k":true}]}
+		"bf 61 66 f9 3e 00 61 67 39 03 e7 61 68 9f 01 a1 61 6b f5 ff ff",
+	}
+	expected := []string{
+		"a=1 b=x c=AQL/",
+		"d=-2 e=<nil> t=1970-01-01T00:00:00Z",
+		"f=1.5 g=-1000 h=[1 map[k:true]]",
+	}
+
+	cases := []struct {
+		name     string
+		raw      string
+		opts     Options
+		expected []string
+		willFail bool
+	}{
+		{"sequence", strings.Join(records, ""), Options{}, expected, false},
+		{"array", "83" + strings.Join(records, ""), Options{}, expected, false},
+		{"self-described array", "d9 d9 f7 9f" + strings.Join(records, "") + "ff", Options{}, expected, false},
+		{"hex binary", records[0], Options{BinaryEncoding: HexEncoding}, []string{"a=1 b=x c=0102ff"}, false},
+		{"chunked strings", "a1 7f 61 61 61 62 ff 5f 41 01 41 02 ff", Options{BinaryEncoding: HexEncoding}, []string{"ab=0102"}, false},
+		{"bignum", "a1 61 6e c2 49 01 00 00 00 00 00 00 00 00", Options{}, []string{"n=1.8446744073709552e+19"}, false},
+		{"empty", "", Options{}, []string{}, false},
+		{"non-map record", records[0] + "01", Options{}, expected[:1], true},
+		{"truncated record", records[0] + "a1 61 61", Options{}, expected[:1], true},
+		{"unexpected break", "a1 61 61 ff", Options{}, []string{}, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			source := NewCborSource(bytes.NewReader(decodeHex(t, tc.raw)), tc.opts)
+			for pass := 1; pass <= 2; pass++ {
+				found, err := readSource(source)
+				if (err != nil) != tc.willFail {
+					t.Fatalf("expected failure: %t, found: %v", tc.willFail, err)
+				}
+				if strings.Join(found, "\n") != strings.Join(tc.expected, "\n") {
+					t.Logf("pass %d did not match expected records", pass)
+					t.Logf("Expected:\n%s", strings.Join(tc.expected, "\n"))
+					t.Logf("Found:\n%s", strings.Join(found, "\n"))
+					t.FailNow()
+				}
+				if err := source.Reset(); err != nil {
+					t.Fatalf("failed to reset source: %s", err.Error())
+				}
+			}
+		})
+	}
+}
+
+func TestHalfFloat(t *testing.T) {
+	t.Parallel()
+
+	cases := map[uint16]float64{
+		0x0000: 0,
+		0x3c00: 1,
+		0xc000: -2,
+		0x7bff: 65504,
+		0x0001: 5.960464477539063e-08,
+	}
+	for bits, expected := range cases {
+		if value := halfFloat(bits); value != expected {
+			t.Errorf("0x%04x: expected %g, found %g", bits, expected, value)
+		}
+	}
+}
@@ -4,38 +4,228 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"gitlab.com/mikattack/fjson2csv"
 )
 
 var (
+	batchSize          = flag.Int("b", 1000, "Records per database transaction")
+	aggregates         = flag.String("agg", "", "Aggregate columns of groups")
+	binary             = flag.String("binary", fjson2csv.Base64Encoding, "Text encoding of binary values")
+	booleans           = flag.String("bool", "", "Spellings of true and false")
+	conflicts          = flag.String("conflicts", fjson2csv.StringPolicy, "Handling of fields with conflicting types")
+	copyRecords        = flag.Bool("c", false, "Use COPY in Postgres SQL scripts")
+	dedup              = flag.String("dedup", "", "Occurrence of duplicate records kept")
+	dedupBy            = flag.String("dedup-by", "", "Columns identifying duplicate records")
+	dedupMemory        = flag.Int("dedup-memory", 0, "Maximum duplicate keys held in memory")
+	dialect            = flag.String("d", fjson2csv.PostgresDialect, "SQL dialect")
+	emptyNull          = flag.Bool("empty-null", false, "Treat empty strings as null")
+	epochs             = flag.String("epoch", "", "Units of epoch timestamp columns")
+	filter             = flag.String("filter", "", "Expression selecting records")
+	filterCols         = flag.Bool("filter-columns", false, "Exclude columns of filtered records")
+	format             = flag.String("f", fjson2csv.CsvFormat, "Output format")
+	from               = flag.String("from", fjson2csv.JsonFormat, "Input format")
+	groupBy            = flag.String("group-by", "", "Columns grouping records")
+	groupSize          = flag.Int("g", 100000, "Records per Parquet row group")
 	help               = flag.Bool("h", false, "Usage instructions")
 	incremental        = flag.Bool("i", false, "Enable incremental conversion")
+	keyColumn          = flag.String("k", "", "Column for member names of records keyed by ID")
+	limit              = flag.Int("limit", 0, "Maximum rows written")
+	missingText        = flag.String("missing", "", "CSV text of missing values")
+	nullText           = flag.String("null", "", "CSV text of null values")
+	previewRows        = flag.Int("n", 0, "Maximum rows in Markdown and HTML tables")
+	project            = flag.String("project", "", "Paths of the values written as columns")
+	offset             = flag.Int("offset", 0, "Rows skipped before writing")
+	path               = flag.String("p", "", "Path to the array of records")
+	policy             = flag.String("policy", fjson2csv.CoercePolicy, "Handling of schema violations")
 	readBuffer         = flag.Int("r", 1024, "Internal read buffer size")
+	reverse            = flag.Bool("reverse", false, "Convert CSV input back into JSON")
+	sampleRate         = flag.Float64("sample", 0, "Probability of sampling each record")
+	sampleSize         = flag.Int("sample-n", 0, "Number of records sampled")
+	schemaFile         = flag.String("schema", "", "Schema giving the output columns")
+	seed               = flag.Int64("seed", 0, "Seed of random samples")
+	sortBy             = flag.String("sort-by", "", "Columns the rows are sorted by")
+	sortMemory         = flag.Int("sort-memory", 100000, "Maximum records sorted in memory")
+	table              = flag.String("t", "records", "Table name for database output")
+	timeLayout         = flag.String("time-layout", "", "Layout of reformatted timestamps")
+	timeZone           = flag.String("tz", "", "Time zone of reformatted timestamps")
+	types              = flag.String("types", "", "JSON types of CSV columns")
+	unflatten          = flag.Bool("unflatten", false, "Nest dotted CSV columns in objects")
 	writeBuffer        = flag.Int("w", 1024, "Internal write buffer size")
 	version     string = "1.0"
 	usage       string = `fjson2csv (v%s)
 
 Converts a collection of flat, heterogeneous records from JSON format into
-CSV format, writing the results to the given output file.
+CSV (or another tabular) format, writing the results to the given output file.
 
 By default, the conversion loads the entire file into memory. Use the '-u'
 option to convert very large files incrementally.
 
 Usage:
   fjson2csv [input] [output]
+  fjson2csv schema [input] [output]
 
 Options
+  -b  Set number of records inserted per transaction for database output,
+      or per INSERT statement for SQL scripts (default: 1000)
+  -c  Load records with COPY rather than INSERT in Postgres SQL scripts
+  -d  SQL dialect of SQL scripts, one of: postgres, mysql (default: postgres)
+  -f  Output format, one of: csv, xlsx, parquet, sqlite, sql, markdown, html
+      (default: csv)
+  -g  Set number of records per Parquet row group (default: 100000)
   -h  This help menu
   -i  Enable incremental conversion
+  -k  Read records from an object keyed by ID (eg. {"u1":{...},"u2":{...}}),
+      writing each member name to a column with the given name
+  -n  Set maximum number of rows in Markdown and HTML tables, for previews
+      (default: all rows)
+  -p  Path to the records within the document, as a JSON Pointer
+      ("/data/items") or dotted path ("data.items") (default: document root)
   -r  Set internal read buffer size in KB (default: 1024)
+  -t  Set table name for database output (default: records)
   -w  Set internal write buffer size in KB (default: 1024)
 
+Input
+  -from    Input format, one of: json, ndjson (newline delimited objects),
+           msgpack, cbor, yaml (default: json)
+  -binary  Text encoding of binary MessagePack, CBOR and YAML values, one of:
+           base64, hex (default: base64)
+
+Projection
+  -project  Write exactly the given columns, as a comma separated list of
+            paths within each record with optional aliases, in the manner
+            of jq (eg. 'id, user.name as name, tags[0] as first_tag').
+            Paths may reach into nested objects and arrays, and negative
+            indexes count from the end of arrays. Objects and arrays are
+            written as JSON text.
+
+Filtering
+  -filter          Only write records for which the given expression is true
+                   (eg. 'status == "active" && age > 30'). Expressions
+                   compare properties with strings, numbers, true, false
+                   and null using ==, !=, <, <=, >, >=, combined with &&, ||
+                   and ! and grouped with parentheses. Unusual property
+                   names can be quoted in backticks.
+  -filter-columns  Exclude columns only found in records left out by -filter
+                   (or sampling)
+
+Limits and sampling
+  -limit     Write at most the given number of rows (default: all rows)
+  -offset    Skip the given number of rows before writing (default: 0)
+  -sample    Sample records with the given probability (eg. 0.01)
+  -sample-n  Sample exactly the given number of records
+  -seed      Seed of random samples, which are the same for the same seed
+             (default: 0)
+
+  Samples are drawn from the records selected by -filter. Rows are counted
+  by -offset and -limit after sampling, deduplication and sorting.
+
+Derived columns
+  -derive  Add a column computed from each record, given as name=expression
+           (eg. 'full_name=first_name + " " + last_name'). Expressions may
+           also use arithmetic (+, -, *, /, %), coalesce(value, ...),
+           substr(string, start[, length]) and the date parts year, month,
+           day, hour, minute and second. Repeat for more columns.
+
+Deduplication
+  -dedup         Drop duplicate records, keeping either their first or last
+                 occurrence, one of: first, last
+  -dedup-by      Columns identifying duplicate records, as a comma separated
+                 list (default: all properties of the records)
+  -dedup-memory  Set maximum number of distinct records held in memory while
+                 finding duplicates, beyond which they are kept in temporary
+                 files (default: no maximum)
+
+Sorting
+  -sort-by      Sort rows by the given columns, as a comma separated list in
+                order of precedence. Columns named with a leading '-' are
+                sorted in descending order (eg. "team,-score"). Numeric and
+                boolean columns are sorted as numbers, others as text, with
+                null values last.
+  -sort-memory  Set maximum number of records sorted in memory, beyond which
+                they are sorted in batches kept in temporary files
+                (default: 100000)
+
+Aggregation
+  -group-by  Write a row for each group of records with the same values in
+             the given columns (a comma separated list), rather than the
+             records themselves
+  -agg       Aggregate columns of each group, as a comma separated list of
+             functions with optional aliases (eg. 'count, sum(score),
+             avg(score) as mean'), one of: count (of records, or of a
+             column's non-null values), sum, min, max, avg, count_distinct
+             (default: count). Without -group-by, all records make a single
+             group.
+
+Null values and formatting
+  -null        Text written to CSV and XLSX for null values (eg. '\N' or
+               NULL) (default: empty)
+  -missing     Text written to CSV and XLSX for properties missing from a
+               record (default: empty)
+  -empty-null  Treat empty strings as null values
+  -bool        Spellings of true and false in CSV, XLSX, Markdown and HTML
+               output, as true/false (eg. 1/0, Y/N, TRUE/FALSE)
+               (default: true/false)
+
+Timestamps
+  -time-layout  Reformat timestamps with the given layout, written as Go's
+                reference time (eg. "2006-01-02 15:04:05"). String fields
+                whose values are all timestamps or dates are reformatted.
+                (default: RFC 3339, when -tz or -epoch is given)
+  -tz           Reformat timestamps in the given time zone (eg. UTC,
+                America/New_York) (default: UTC)
+  -epoch        Columns of numeric timestamps, as a comma separated list of
+                name:unit pairs with units: s, ms, auto (seconds, or
+                milliseconds when too large to be seconds)
+                (eg. "created:s,seen:auto")
+
+Type conflicts
+  -conflicts  Handling of fields whose values have conflicting types (eg.
+              "02134" in one record and 2134 in another), one of: string
+              (write them as strings), number (write them as numbers, or
+              null where not a number), report (write strings, and list
+              conflicts on stderr), fail (default: string). Columns may be
+              given their own policy as name:policy pairs
+              (eg. "report,zip:number").
+
+Reverse conversion (CSV to JSON)
+  -reverse    Convert CSV input back into JSON, written as an array (-f json,
+              the default) or newline delimited objects (-f ndjson)
+  -types      JSON types of columns, as a comma separated list of name:type
+              pairs with types: string, integer, number, boolean
+              (eg. "id:integer,active:boolean") (default: all strings)
+  -unflatten  Nest values of dotted columns (eg. "user.name") inside objects
+
+Schemas
+  -schema  Take output columns and their types from a JSON Schema document
+           or column manifest (as written by the 'schema' command), rather
+           than discovering them first. Input is then only read once.
+  -policy  Handling of values violating their column's type, one of: coerce
+           (convert where possible, otherwise write null), report (coerce,
+           and list violations on stderr), fail (default: coerce)
+
+Schema inference
+  Use the 'schema' command to describe the fields of the input's records,
+  with the types, string lengths and examples of their values, rather than
+  converting them. Output formats (-f) are a JSON Schema document
+  (jsonschema, the default) or a column manifest (manifest).
+
 `
 )
 
+func init() {
+	flag.Var(&derived, "derive", "Column computed from each record")
+}
+
 func main() {
-	flag.Parse()
+	schema := len(os.Args) > 1 && os.Args[1] == "schema"
+	if schema {
+		flag.CommandLine.Parse(os.Args[2:])
+	} else {
+		flag.Parse()
+	}
 
 	var (
 		src *os.File
@@ -47,25 +237,34 @@ func main() {
 		fmt.Printf(usage, version)
 		os.Exit(0)
 	}
-	if len(os.Args) < 3 {
-		fmt.Printf("Missing JSON input filename\n")
+
+	// Both the conversion and the schema command take an input and an output
+	// file, after any options
+	files := flag.CommandLine.Args()
+	switch {
+	case len(files) == 0:
+		fmt.Printf("Missing input filename\n")
+		os.Exit(1)
+	case len(files) == 1:
+		fmt.Printf("Missing output filename\n")
+		os.Exit(1)
+	case len(files) > 2:
+		fmt.Printf("Unexpected arguments: %s (options must precede the filenames)\n", strings.Join(files[2:], " "))
 		os.Exit(1)
 	}
-
-	files := os.Args[len(os.Args)-2:]
-	inputfile := string(files[0])
-	outputfile := string(files[1])
+	inputfile := files[0]
+	outputfile := files[1]
 
 	src, err = os.Open(inputfile)
 	if err != nil {
-		fmt.Printf("Failed to read JSON input data: %s\n", err.Error())
+		fmt.Printf("Failed to read input data: %s\n", err.Error())
 		os.Exit(1)
 	}
 	defer src.Close()
 
 	dst, err = os.Create(outputfile)
 	if err != nil {
-		fmt.Printf("Failed open CSV output file for writing: %s\n", err.Error())
+		fmt.Printf("Failed open output file for writing: %s\n", err.Error())
 		os.Exit(1)
 	}
 	defer dst.Close()
@@ -73,9 +272,100 @@ func main() {
 	opts := fjson2csv.Options{
 		ReadBufferSize:  *readBuffer,
 		WriteBufferSize: *writeBuffer,
+		Path:            *path,
+		KeyColumn:       *keyColumn,
+		InputFormat:     *from,
+		BinaryEncoding:  *binary,
+		Projection:      *project,
+		Filter:          *filter,
+		DerivedColumns:  derived,
+		FilterColumns:   *filterCols,
+		Dedup:           *dedup,
+		DedupMemory:     *dedupMemory,
+		SortMemory:      *sortMemory,
+		Offset:          *offset,
+		Limit:           *limit,
+		SampleRate:      *sampleRate,
+		SampleSize:      *sampleSize,
+		SampleSeed:      *seed,
+		Format:          *format,
+		RowGroupSize:    *groupSize,
+		Table:           *table,
+		BatchSize:       *batchSize,
+		Dialect:         *dialect,
+		Copy:            *copyRecords,
+		PreviewRows:     *previewRows,
+		NullValue:       *nullText,
+		MissingValue:    *missingText,
+		EmptyAsNull:     *emptyNull,
+		TimeLayout:      *timeLayout,
+		SchemaPolicy:    *policy,
+		Unflatten:       *unflatten,
+	}
+
+	if *booleans != "" {
+		spellings := strings.Split(*booleans, "/")
+		if len(spellings) != 2 {
+			fmt.Printf("invalid boolean spellings '%s'\n", *booleans)
+			os.Exit(1)
+		}
+		opts.TrueValue, opts.FalseValue = spellings[0], spellings[1]
+	}
+
+	if *dedupBy != "" {
+		opts.DedupColumns = strings.Split(*dedupBy, ",")
+	}
+
+	if *groupBy != "" {
+		opts.GroupBy = strings.Split(*groupBy, ",")
+	}
+
+	if opts.Aggregates, err = parseAggregates(*aggregates); err != nil {
+		fmt.Printf("%s\n", err.Error())
+		os.Exit(1)
+	}
+
+	if *sortBy != "" {
+		opts.SortBy = strings.Split(*sortBy, ",")
 	}
 
-	if *incremental {
+	if *timeZone != "" {
+		if opts.TimeZone, err = time.LoadLocation(*timeZone); err != nil {
+			fmt.Printf("Invalid time zone: %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	if opts.EpochColumns, err = parsePairs(*epochs, "epoch column"); err != nil {
+		fmt.Printf("%s\n", err.Error())
+		os.Exit(1)
+	}
+
+	if opts.ConflictPolicy, opts.ColumnPolicies, err = parseConflicts(*conflicts); err != nil {
+		fmt.Printf("%s\n", err.Error())
+		os.Exit(1)
+	}
+
+	if *schemaFile != "" {
+		if opts.Schema, err = readSchema(*schemaFile); err != nil {
+			fmt.Printf("Failed to read schema: %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	if schema {
+		if opts.Format == fjson2csv.CsvFormat {
+			opts.Format = fjson2csv.JsonSchemaFormat
+		}
+		err = fjson2csv.InferSchema(src, dst, opts)
+	} else if *reverse {
+		if opts.Format == fjson2csv.CsvFormat {
+			opts.Format = fjson2csv.JsonFormat
+		}
+		if opts.ColumnTypes, err = parsePairs(*types, "column type"); err == nil {
+			err = fjson2csv.Csv2Json(src, dst, opts)
+		}
+	} else if *incremental {
 		err = fjson2csv.UnbufferedConvert(src, dst, opts)
 	} else {
 		err = fjson2csv.BufferedConvert(src, dst, opts)
@@ -85,3 +375,92 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// Derived columns given with repeated flags.
+var derived derivedColumns
+
+type derivedColumns []fjson2csv.DerivedColumn
+
+func (d *derivedColumns) String() string {
+	return fmt.Sprint(*d)
+}
+
+// Parses a derived column, given as "name=expression".
+func (d *derivedColumns) Set(value string) error {
+	i := strings.Index(value, "=")
+	if i < 1 {
+		return fmt.Errorf("invalid derived column '%s'", value)
+	}
+	*d = append(*d, fjson2csv.DerivedColumn{Name: strings.TrimSpace(value[:i]), Expression: value[i+1:]})
+	return nil
+}
+
+// Reads output columns from a schema file.
+func readSchema(filename string) ([]fjson2csv.Column, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return fjson2csv.ReadSchema(file)
+}
+
+// Parses a list of settings of columns (eg. their types), given as
+// "name:value,name:value".
+func parsePairs(list string, kind string) (map[string]string, error) {
+	values := map[string]string{}
+	if list == "" {
+		return values, nil
+	}
+	for _, pair := range strings.Split(list, ",") {
+		i := strings.LastIndex(pair, ":")
+		if i < 1 {
+			return nil, fmt.Errorf("invalid %s '%s'", kind, pair)
+		}
+		values[pair[:i]] = pair[i+1:]
+	}
+	return values, nil
+}
+
+// Parses aggregate columns, given as a comma separated list of functions
+// (eg. "count, sum(score) as total").
+func parseAggregates(list string) ([]fjson2csv.Aggregate, error) {
+	aggregates := []fjson2csv.Aggregate{}
+	if strings.TrimSpace(list) == "" {
+		return aggregates, nil
+	}
+	for _, item := range strings.Split(list, ",") {
+		agg := fjson2csv.Aggregate{}
+		spec := strings.TrimSpace(item)
+		if i := strings.Index(spec, " as "); i >= 0 {
+			spec, agg.Name = strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+4:])
+		}
+		if i := strings.Index(spec, "("); i >= 0 {
+			if strings.HasSuffix(spec, ")") == false {
+				return nil, fmt.Errorf("invalid aggregate '%s'", item)
+			}
+			spec, agg.Column = spec[:i], strings.TrimSpace(spec[i+1:len(spec)-1])
+		}
+		agg.Function = strings.TrimSpace(spec)
+		aggregates = append(aggregates, agg)
+	}
+	return aggregates, nil
+}
+
+// Parses conflict policies, given as "policy,name:policy,name:policy". The
+// policy without a column name is the default.
+func parseConflicts(list string) (string, map[string]string, error) {
+	policy := ""
+	policies := map[string]string{}
+	for _, pair := range strings.Split(list, ",") {
+		i := strings.LastIndex(pair, ":")
+		if i < 0 {
+			policy = pair
+			continue
+		} else if i < 1 {
+			return "", nil, fmt.Errorf("invalid conflict policy '%s'", pair)
+		}
+		policies[pair[:i]] = pair[i+1:]
+	}
+	return policy, policies, nil
+}
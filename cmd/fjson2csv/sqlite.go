@@ -0,0 +1,4 @@
+package main
+
+// Registers the pure-Go (cgo-free) SQLite driver used for SQLite output.
+import _ "modernc.org/sqlite"
@@ -0,0 +1,226 @@
+package fjson2csv
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Formats of JSON input, and of output from reverse (CSV to JSON)
+// conversions.
+const (
+	JsonFormat   string = "json"
+	NdjsonFormat string = "ndjson"
+)
+
+// Converts CSV back into JSON, as an array of objects (`JsonFormat`, the
+// default) or newline delimited objects (`NdjsonFormat`).
+//
+// The first row of input names the properties of each object. Empty fields
+// are left out of their object, mirroring how missing properties are written
+// as empty fields. Values are strings, unless `Options.ColumnTypes` gives the
+// type of their column. With `Options.Unflatten`, dotted column names (eg.
+// "user.name") become nested objects.
+func Csv2Json(r io.Reader, w io.Writer, opts Options) error {
+	rsize, wsize := getBufferSizes(opts)
+	format := opts.Format
+	if format == "" {
+		format = JsonFormat
+	}
+	if format != JsonFormat && format != NdjsonFormat {
+		return fmt.Errorf("unsupported output format: %s", format)
+	}
+
+	reader := csv.NewReader(bufio.NewReaderSize(r, rsize))
+	reader.Comma, _ = utf8.DecodeRuneInString(default_delimiter)
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		header = []string{}
+	} else if err != nil {
+		return err
+	}
+	columns, err := newCsvColumns(header, opts)
+	if err != nil {
+		return err
+	}
+
+	ew := newErrorWriter(w, wsize)
+	if format == JsonFormat {
+		ew.write("[")
+	}
+	for line := 2; ; line++ {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		record := newOrderedObject()
+		for i, field := range fields {
+			if field == "" {
+				continue
+			}
+			value, err := parseField(field, columns[i].kind)
+			if err != nil {
+				return fmt.Errorf("line %d: column '%s': %s", line, columns[i].name, err.Error())
+			}
+			record.set(columns[i].path, value)
+		}
+		encoded := bytes.Buffer{}
+		if err := record.encode(&encoded); err != nil {
+			return err
+		}
+
+		if format == JsonFormat {
+			if line > 2 {
+				ew.write(",")
+			}
+			ew.write("\n")
+		}
+		ew.write(encoded.String())
+		if format == NdjsonFormat {
+			ew.write("\n")
+		}
+		if ew.err != nil {
+			return ew.err
+		}
+	}
+	if format == JsonFormat {
+		ew.write("\n]\n")
+	}
+	ew.flush()
+	return ew.err
+}
+
+// Describes a column of CSV input.
+type csvColumn struct {
+	name string
+	path []string
+	kind string
+}
+
+// Describes the columns named by a CSV header row, checking that they can all
+// be placed in the same object.
+func newCsvColumns(header []string, opts Options) ([]csvColumn, error) {
+	columns := make([]csvColumn, len(header))
+	paths := map[string]string{}
+	for i, name := range header {
+		columns[i] = csvColumn{name: name, path: []string{name}, kind: opts.ColumnTypes[name]}
+		if opts.Unflatten {
+			columns[i].path = strings.Split(name, ".")
+		}
+		switch columns[i].kind {
+		case "", "string", "integer", "number", "boolean":
+		default:
+			return nil, fmt.Errorf("column '%s': unsupported type '%s'", name, columns[i].kind)
+		}
+
+		// Neither the column nor any of its parent objects may already be
+		// a value of another column
+		for depth := range columns[i].path {
+			prefix := strings.Join(columns[i].path[:depth+1], ".")
+			if other, ok := paths[prefix]; ok == true && (other != "" || depth == len(columns[i].path)-1) {
+				return nil, fmt.Errorf("column '%s' conflicts with another column", name)
+			}
+		}
+		for depth := range columns[i].path {
+			prefix := strings.Join(columns[i].path[:depth+1], ".")
+			if depth == len(columns[i].path)-1 {
+				paths[prefix] = name
+			} else {
+				paths[prefix] = ""
+			}
+		}
+	}
+	return columns, nil
+}
+
+// Converts a CSV field into a value of the given JSON type.
+func parseField(field string, kind string) (interface{}, error) {
+	switch kind {
+	case "", "string":
+		return field, nil
+	case "integer":
+		if v, err := strconv.ParseInt(field, 10, 64); err == nil {
+			return v, nil
+		}
+	case "number":
+		if v, err := strconv.ParseFloat(field, 64); err == nil {
+			return v, nil
+		}
+	case "boolean":
+		if v, err := strconv.ParseBool(field); err == nil {
+			return v, nil
+		}
+	default:
+		return nil, fmt.Errorf("unsupported type '%s'", kind)
+	}
+	return nil, fmt.Errorf("invalid %s '%s'", kind, field)
+}
+
+// JSON object which keeps its members in the order they were added.
+type orderedObject struct {
+	keys    []string
+	members map[string]interface{}
+}
+
+func newOrderedObject() *orderedObject {
+	return &orderedObject{members: map[string]interface{}{}}
+}
+
+// Sets the value at a path of nested objects, creating them as needed.
+func (o *orderedObject) set(path []string, value interface{}) {
+	key := path[0]
+	if len(path) > 1 {
+		child, ok := o.members[key].(*orderedObject)
+		if ok == false {
+			child = newOrderedObject()
+			o.keys = append(o.keys, key)
+			o.members[key] = child
+		}
+		child.set(path[1:], value)
+		return
+	}
+	o.keys = append(o.keys, key)
+	o.members[key] = value
+}
+
+// Encodes the object as JSON. Unlike `json.Marshal`, HTML characters are
+// left unescaped.
+func (o *orderedObject) encode(buffer *bytes.Buffer) error {
+	enc := json.NewEncoder(buffer)
+	enc.SetEscapeHTML(false)
+
+	buffer.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+		if err := enc.Encode(key); err != nil {
+			return err
+		}
+		buffer.Truncate(buffer.Len() - 1)
+		buffer.WriteByte(':')
+		if child, ok := o.members[key].(*orderedObject); ok == true {
+			if err := child.encode(buffer); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := enc.Encode(o.members[key]); err != nil {
+			return err
+		}
+		buffer.Truncate(buffer.Len() - 1)
+	}
+	buffer.WriteByte('}')
+	return nil
+}
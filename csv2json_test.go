@@ -0,0 +1,131 @@
+package fjson2csv
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCsv2Json(t *testing.T) {
+	t.Parallel()
+
+	raw := "id,user.name,user.admin,note\n1,Jane,true,\"a, <b>\"\n2,,false,\n"
+
+	cases := []struct {
+		name     string
+		opts     Options
+		expected string
+		willFail bool
+	}{
+		{
+			"strings",
+			Options{},
+			"[\n" +
+				`{"id":"1","user.name":"Jane","user.admin":"true","note":"a, <b>"},` + "\n" +
+				`{"id":"2","user.admin":"false"}` + "\n]\n",
+			false,
+		},
+		{
+			"typed ndjson",
+			Options{Format: NdjsonFormat, ColumnTypes: map[string]string{"id": "integer", "user.admin": "boolean"}},
+			`{"id":1,"user.name":"Jane","user.admin":true,"note":"a, <b>"}` + "\n" +
+				`{"id":2,"user.admin":false}` + "\n",
+			false,
+		},
+		{
+			"unflatten",
+			Options{Format: NdjsonFormat, Unflatten: true},
+			`{"id":"1","user":{"name":"Jane","admin":"true"},"note":"a, <b>"}` + "\n" +
+				`{"id":"2","user":{"admin":"false"}}` + "\n",
+			false,
+		},
+		{"invalid value", Options{ColumnTypes: map[string]string{"user.name": "number"}}, "", true},
+		{"unsupported type", Options{ColumnTypes: map[string]string{"id": "date"}}, "", true},
+		{"unsupported format", Options{Format: CsvFormat}, "", true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			buffer := bytes.Buffer{}
+			err := Csv2Json(strings.NewReader(raw), &buffer, tc.opts)
+			if tc.willFail {
+				if err == nil {
+					t.Errorf("expected failure")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("conversion failure: %s", err.Error())
+			}
+			if buffer.String() != tc.expected {
+				t.Logf("JSON output did not match")
+				t.Logf("Expected:\n%s", tc.expected)
+				t.Logf("Found:\n%s", buffer.String())
+				t.FailNow()
+			}
+		})
+	}
+}
+
+func TestCsv2JsonRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	// JSON > CSV > JSON > CSV should reproduce the original CSV
+	converted := bytes.Buffer{}
+	if err := Csv2Json(strings.NewReader(rawCsv), &converted, Options{}); err != nil {
+		t.Fatalf("conversion failure: %s", err.Error())
+	}
+	buffer := bytes.Buffer{}
+	if err := BufferedConvert(bytes.NewReader(converted.Bytes()), &buffer, Options{}); err != nil {
+		t.Fatalf("conversion failure: %s", err.Error())
+	}
+	if buffer.String() != rawCsv {
+		t.Logf("round trip did not reproduce CSV")
+		t.Logf("Expected:\n%s", rawCsv)
+		t.Logf("Found:\n%s", buffer.String())
+		t.FailNow()
+	}
+}
+
+func TestCsvQuotedRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	// Fields with delimiters, quotes and line breaks survive JSON > CSV > JSON
+	raw := "[\n" +
+		`{"a b":"x, y","quote":"he said \"hi\"","text":"line\nbreak"},` + "\n" +
+		`{"a b":" padded","quote":"\"","text":"tab\tend"}` + "\n]\n"
+	expected := "a b,quote,text\n" +
+		"\"x, y\",\"he said \"\"hi\"\"\",\"line\nbreak\"\n" +
+		"\" padded\",\"\"\"\",tab\tend\n"
+
+	for name, csv := range convertBoth(t, raw, Options{}) {
+		checkOutput(t, name+" conversion", expected, string(csv))
+		buffer := bytes.Buffer{}
+		if err := Csv2Json(bytes.NewReader(csv), &buffer, Options{}); err != nil {
+			t.Fatalf("%s round trip failure: %s", name, err.Error())
+		}
+		checkOutput(t, name+" round trip", raw, buffer.String())
+	}
+}
+
+func TestCsvColumnConflicts(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		header   []string
+		willFail bool
+	}{
+		{"siblings", []string{"a.b", "a.c", "b"}, false},
+		{"duplicate", []string{"a", "a"}, true},
+		{"parent after child", []string{"a.b", "a"}, true},
+		{"child after parent", []string{"a", "a.b"}, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := newCsvColumns(tc.header, Options{Unflatten: true})
+			if (err != nil) != tc.willFail {
+				t.Errorf("expected failure: %t, found: %v", tc.willFail, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,376 @@
+package fjson2csv
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"os"
+	"sort"
+)
+
+// Occurrences of duplicate records kept by `Options.Dedup`.
+const (
+	KeepFirst string = "first"
+	KeepLast  string = "last"
+)
+
+// Size of a record's key hash, and of an index entry (a hash and the number
+// of the record it belongs to) in temporary files.
+const (
+	dedup_hash_size  int = 16
+	dedup_entry_size int = dedup_hash_size + 8
+)
+
+type recordHash [dedup_hash_size]byte
+
+/*
+ * Duplicates are found in two steps. While indexing, the key of each selected
+ * record is hashed, and the number of the record to keep (its first or last
+ * occurrence) is noted for each hash. While writing, records are numbered the
+ * same way, and only those noted are written.
+ *
+ * Hashes are kept in memory, unless there are more than `Options.DedupMemory`
+ * of them. Hashes are then written to temporary files in sorted runs, which
+ * are merged into a single sorted file once indexing is done, and searched
+ * while writing.
+ */
+type deduplicator struct {
+	columns  []string
+	keepLast bool
+	limit    int
+
+	// Columns found in the records observed, to catch unknown ones
+	seen map[string]bool
+
+	// Numbers of the records observed while indexing, and checked while
+	// writing
+	observed int64
+	checked  int64
+
+	memory map[recordHash]int64
+	runs   []*os.File
+	merged *os.File
+	size   int64
+	done   bool
+}
+
+func newDeduplicator(opts Options) (*deduplicator, error) {
+	switch opts.Dedup {
+	case "":
+		if len(opts.DedupColumns) > 0 {
+			return nil, fmt.Errorf("dedup columns given without a dedup policy")
+		}
+		return nil, nil
+	case KeepFirst, KeepLast:
+	default:
+		return nil, fmt.Errorf("unsupported dedup policy: %s", opts.Dedup)
+	}
+	return &deduplicator{
+		columns:  opts.DedupColumns,
+		keepLast: opts.Dedup == KeepLast,
+		limit:    opts.DedupMemory,
+		seen:     map[string]bool{},
+		memory:   map[recordHash]int64{},
+	}, nil
+}
+
+// Checks that the dedup columns were found in the records observed (if any).
+// Otherwise, every record would have the same key.
+func (d *deduplicator) prepare() error {
+	if d.observed == 0 {
+		return nil
+	}
+	for _, col := range d.columns {
+		if d.seen[col] == false {
+			return fmt.Errorf("unsupported dedup column: %s", col)
+		}
+	}
+	return nil
+}
+
+// Finds duplicates in a pass of their own, when no other pass indexes the
+// records (ie. when a schema is given).
+func (c *converter) findDuplicates() {
+	if c.dedup != nil && c.err == nil {
+		c.WalkJsonList(observeDuplicates, c)
+	}
+}
+
+// Callback function which notes the key of a record selected by the filter
+// (and sampling).
+func observeDuplicates(record map[string]interface{}, args ...interface{}) error {
+	c := args[0].(*converter)
+	if c.includes(record) == false {
+		return nil
+	}
+	return c.dedup.observe(record)
+}
+
+// Notes the key of a record read while indexing.
+func (d *deduplicator) observe(record map[string]interface{}) error {
+	d.observed++
+	if len(d.seen) < len(d.columns) {
+		for _, col := range d.columns {
+			if _, ok := record[col]; ok == true {
+				d.seen[col] = true
+			}
+		}
+	}
+	key := d.hash(record)
+	if _, ok := d.memory[key]; ok == false || d.keepLast {
+		d.memory[key] = d.observed
+	}
+	if d.limit > 0 && len(d.memory) >= d.limit {
+		return d.spill()
+	}
+	return nil
+}
+
+// Reports whether a record read while writing is the occurrence of its key
+// to keep.
+func (d *deduplicator) keeps(record map[string]interface{}) (bool, error) {
+	if d.done == false {
+		if err := d.finish(); err != nil {
+			return false, err
+		}
+	}
+	d.checked++
+	key := d.hash(record)
+	if d.merged == nil {
+		return d.memory[key] == d.checked, nil
+	}
+
+	// Binary search of the merged file
+	entry := make([]byte, dedup_entry_size)
+	var err error
+	i := sort.Search(int(d.size), func(i int) bool {
+		if _, readErr := d.merged.ReadAt(entry, int64(i*dedup_entry_size)); readErr != nil {
+			err = readErr
+			return true
+		}
+		return bytes.Compare(entry[:dedup_hash_size], key[:]) >= 0
+	})
+	if err != nil {
+		return false, fmt.Errorf("dedup index failure: %s", err.Error())
+	}
+	if i >= int(d.size) {
+		return false, nil
+	}
+	if _, err := d.merged.ReadAt(entry, int64(i*dedup_entry_size)); err != nil {
+		return false, fmt.Errorf("dedup index failure: %s", err.Error())
+	}
+	n := int64(binary.BigEndian.Uint64(entry[dedup_hash_size:]))
+	return bytes.Equal(entry[:dedup_hash_size], key[:]) && n == d.checked, nil
+}
+
+// Writes the hashes in memory to a temporary file, in order.
+func (d *deduplicator) spill() error {
+	keys := make([]recordHash, 0, len(d.memory))
+	for key := range d.memory {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i][:], keys[j][:]) < 0
+	})
+
+	file, err := os.CreateTemp("", "fjson2csv-dedup-*")
+	if err != nil {
+		return fmt.Errorf("dedup index failure: %s", err.Error())
+	}
+	d.runs = append(d.runs, file)
+	w := bufio.NewWriter(file)
+	entry := make([]byte, dedup_entry_size)
+	for _, key := range keys {
+		copy(entry, key[:])
+		binary.BigEndian.PutUint64(entry[dedup_hash_size:], uint64(d.memory[key]))
+		w.Write(entry)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("dedup index failure: %s", err.Error())
+	}
+	d.memory = map[recordHash]int64{}
+	return nil
+}
+
+// Merges the sorted runs of hashes (if any were spilled) into a single
+// sorted file, keeping the first or last record of each hash.
+func (d *deduplicator) finish() error {
+	d.done = true
+	if len(d.runs) == 0 {
+		return nil
+	}
+	if len(d.memory) > 0 {
+		if err := d.spill(); err != nil {
+			return err
+		}
+	}
+
+	readers := make([]*bufio.Reader, len(d.runs))
+	heads := make([][]byte, len(d.runs))
+	for i, run := range d.runs {
+		if _, err := run.Seek(0, 0); err != nil {
+			return fmt.Errorf("dedup index failure: %s", err.Error())
+		}
+		readers[i] = bufio.NewReader(run)
+	}
+	advance := func(i int) error {
+		heads[i] = make([]byte, dedup_entry_size)
+		if _, err := io.ReadFull(readers[i], heads[i]); err == io.EOF {
+			heads[i] = nil
+		} else if err != nil {
+			return fmt.Errorf("dedup index failure: %s", err.Error())
+		}
+		return nil
+	}
+	for i := range readers {
+		if err := advance(i); err != nil {
+			return err
+		}
+	}
+
+	merged, err := os.CreateTemp("", "fjson2csv-dedup-*")
+	if err != nil {
+		return fmt.Errorf("dedup index failure: %s", err.Error())
+	}
+	d.merged = merged
+	w := bufio.NewWriter(merged)
+	for {
+		// Find the smallest hash among the runs, and the record to keep
+		var smallest []byte
+		for _, head := range heads {
+			if head != nil && (smallest == nil || bytes.Compare(head[:dedup_hash_size], smallest) < 0) {
+				smallest = head[:dedup_hash_size]
+			}
+		}
+		if smallest == nil {
+			break
+		}
+		key := append([]byte{}, smallest...)
+		keep := int64(math.MaxInt64)
+		if d.keepLast {
+			keep = 0
+		}
+		for i, head := range heads {
+			for head != nil && bytes.Equal(head[:dedup_hash_size], key) {
+				n := int64(binary.BigEndian.Uint64(head[dedup_hash_size:]))
+				if (d.keepLast && n > keep) || (d.keepLast == false && n < keep) {
+					keep = n
+				}
+				if err := advance(i); err != nil {
+					return err
+				}
+				head = heads[i]
+			}
+		}
+
+		entry := make([]byte, dedup_entry_size)
+		copy(entry, key)
+		binary.BigEndian.PutUint64(entry[dedup_hash_size:], uint64(keep))
+		w.Write(entry)
+		d.size++
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("dedup index failure: %s", err.Error())
+	}
+	d.removeRuns()
+	return nil
+}
+
+// Removes the temporary files of the index.
+func (d *deduplicator) close() {
+	d.removeRuns()
+	if d.merged != nil {
+		d.merged.Close()
+		os.Remove(d.merged.Name())
+		d.merged = nil
+	}
+}
+
+func (d *deduplicator) removeRuns() {
+	for _, run := range d.runs {
+		run.Close()
+		os.Remove(run.Name())
+	}
+	d.runs = nil
+}
+
+// Hashes the key of a record: the values of the dedup columns, or of all
+// its properties.
+func (d *deduplicator) hash(record map[string]interface{}) recordHash {
+	return hashColumns(record, d.columns)
+}
+
+// Hashes the values of the given columns of a record, or of all its
+// properties when no columns are given.
+func hashColumns(record map[string]interface{}, columns []string) recordHash {
+	h := sha256.New()
+	if len(columns) == 0 {
+		hashValue(h, record)
+	} else {
+		for _, col := range columns {
+			if value, ok := record[col]; ok == true {
+				hashValue(h, value)
+			} else {
+				// Missing values differ from null ones
+				h.Write([]byte{'m'})
+			}
+		}
+	}
+	var key recordHash
+	copy(key[:], h.Sum(nil))
+	return key
+}
+
+// Writes an unambiguous encoding of a decoded value to a hash. Properties of
+// objects are written in order of their names.
+func hashValue(h hash.Hash, value interface{}) {
+	word := func(n uint64) {
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], n)
+		h.Write(b[:])
+	}
+
+	switch v := value.(type) {
+	case nil:
+		h.Write([]byte{'n'})
+	case bool:
+		if v {
+			h.Write([]byte{'t'})
+		} else {
+			h.Write([]byte{'f'})
+		}
+	case float64:
+		h.Write([]byte{'d'})
+		word(math.Float64bits(v))
+	case string:
+		h.Write([]byte{'s'})
+		word(uint64(len(v)))
+		h.Write([]byte(v))
+	case []interface{}:
+		h.Write([]byte{'a'})
+		word(uint64(len(v)))
+		for _, item := range v {
+			hashValue(h, item)
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		h.Write([]byte{'o'})
+		word(uint64(len(keys)))
+		for _, key := range keys {
+			hashValue(h, key)
+			hashValue(h, v[key])
+		}
+	default:
+		// Values of other types (eg. from custom sources) by their text
+		hashValue(h, fmt.Sprint(v))
+	}
+}
@@ -0,0 +1,172 @@
+package fjson2csv
+
+import (
+	"crypto/sha256"
+	"testing"
+)
+
+func TestDedupConvert(t *testing.T) {
+	t.Parallel()
+
+	raw := `[
+		{"id":1, "name":"alpha", "score":10},
+		{"id":2, "name":"beta", "score":20},
+		{"id":1, "name":"alpha", "score":10},
+		{"id":3, "name":"alpha", "score":30},
+		{"id":2, "name":"beta", "score":25},
+		{"id":4, "score":40},
+		{"id":5, "name":null, "score":50},
+		{"id":6, "score":60}
+	]`
+
+	cases := []conversionCase{
+		{
+			"no dedup",
+			Options{},
+			"id,score,name\n" +
+				"1,10,alpha\n" +
+				"2,20,beta\n" +
+				"1,10,alpha\n" +
+				"3,30,alpha\n" +
+				"2,25,beta\n" +
+				"4,40,\n" +
+				"5,50,\n" +
+				"6,60,\n",
+			false,
+		},
+		{
+			"whole records",
+			Options{Dedup: KeepFirst},
+			"id,score,name\n" +
+				"1,10,alpha\n" +
+				"2,20,beta\n" +
+				"3,30,alpha\n" +
+				"2,25,beta\n" +
+				"4,40,\n" +
+				"5,50,\n" +
+				"6,60,\n",
+			false,
+		},
+		{
+			"first by column",
+			Options{Dedup: KeepFirst, DedupColumns: []string{"name"}},
+			"id,score,name\n" +
+				"1,10,alpha\n" +
+				"2,20,beta\n" +
+				"4,40,\n" +
+				"5,50,\n",
+			false,
+		},
+		{
+			"last by column",
+			Options{Dedup: KeepLast, DedupColumns: []string{"name"}},
+			"id,score,name\n" +
+				"3,30,alpha\n" +
+				"2,25,beta\n" +
+				"5,50,\n" +
+				"6,60,\n",
+			false,
+		},
+		{
+			"last by columns",
+			Options{Dedup: KeepLast, DedupColumns: []string{"id", "name"}},
+			"id,score,name\n" +
+				"1,10,alpha\n" +
+				"3,30,alpha\n" +
+				"2,25,beta\n" +
+				"4,40,\n" +
+				"5,50,\n" +
+				"6,60,\n",
+			false,
+		},
+		{
+			"first spilled",
+			Options{Dedup: KeepFirst, DedupColumns: []string{"name"}, DedupMemory: 2},
+			"id,score,name\n" +
+				"1,10,alpha\n" +
+				"2,20,beta\n" +
+				"4,40,\n" +
+				"5,50,\n",
+			false,
+		},
+		{
+			"last spilled",
+			Options{Dedup: KeepLast, DedupColumns: []string{"name"}, DedupMemory: 1},
+			"id,score,name\n" +
+				"3,30,alpha\n" +
+				"2,25,beta\n" +
+				"5,50,\n" +
+				"6,60,\n",
+			false,
+		},
+		{
+			"filtered",
+			Options{Dedup: KeepFirst, DedupColumns: []string{"name"}, Filter: "score > 15"},
+			"id,score,name\n" +
+				"2,20,beta\n" +
+				"3,30,alpha\n" +
+				"4,40,\n" +
+				"5,50,\n",
+			false,
+		},
+		{
+			"schema",
+			Options{
+				Dedup:        KeepLast,
+				DedupColumns: []string{"name"},
+				Schema:       []Column{{Name: "id", Type: IntegerColumn}, {Name: "name", Type: StringColumn, Nullable: true}},
+			},
+			"id,name\n" +
+				"3,alpha\n" +
+				"2,beta\n" +
+				"5,\n" +
+				"6,\n",
+			false,
+		},
+		{"unknown policy", Options{Dedup: "middle"}, "", true},
+		{"columns without policy", Options{DedupColumns: []string{"id"}}, "", true},
+		{"unknown column", Options{Dedup: KeepFirst, DedupColumns: []string{"id", "nmae"}}, "", true},
+		{
+			"unknown column with schema",
+			Options{Dedup: KeepFirst, DedupColumns: []string{"nmae"}, Schema: []Column{{Name: "id", Type: IntegerColumn}}},
+			"",
+			true,
+		},
+		{"unknown column with groups", Options{Dedup: KeepFirst, DedupColumns: []string{"nmae"}, GroupBy: []string{"name"}}, "", true},
+	}
+	checkConversions(t, raw, cases)
+}
+
+func TestHashValue(t *testing.T) {
+	t.Parallel()
+
+	hashed := func(value interface{}) string {
+		h := sha256.New()
+		hashValue(h, value)
+		return string(h.Sum(nil))
+	}
+	distinct := [][2]interface{}{
+		{nil, ""},
+		{"1", float64(1)},
+		{true, "true"},
+		{[]interface{}{"ab"}, []interface{}{"a", "b"}},
+		{map[string]interface{}{"a": "b"}, map[string]interface{}{"ab": ""}},
+		{map[string]interface{}{"a": nil}, map[string]interface{}{}},
+	}
+	for _, pair := range distinct {
+		if hashed(pair[0]) == hashed(pair[1]) {
+			t.Errorf("expected %#v and %#v to hash differently", pair[0], pair[1])
+		}
+	}
+
+	a := map[string]interface{}{"x": float64(1), "y": []interface{}{"z", nil}}
+	b := map[string]interface{}{"y": []interface{}{"z", nil}, "x": float64(1)}
+	if hashed(a) != hashed(b) {
+		t.Errorf("expected equal objects to hash the same")
+	}
+
+	d := &deduplicator{columns: []string{"name"}}
+	if d.hash(map[string]interface{}{}) == d.hash(map[string]interface{}{"name": nil}) {
+		t.Errorf("expected missing and null keys to hash differently")
+	}
+}
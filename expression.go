@@ -0,0 +1,625 @@
+package fjson2csv
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+/*
+ * Expressions select the records written by a conversion, eg.:
+ *
+ *   status == "active" && (age > 30 || admin)
+ *
+ * and compute derived columns, eg.:
+ *
+ *   first_name + " " + coalesce(last_name, "")
+ *
+ * They are made of:
+ *
+ *  - Properties of the record, named directly (`age`, `user.name`) or, for
+ *    names with other characters, in backticks (`first name`). Missing
+ *    properties are null.
+ *  - Strings (in double or single quotes), numbers, true, false and null
+ *  - Arithmetic: +, -, *, / and % of numbers. Adding a string concatenates
+ *    (numbers and booleans are written as text). Operations involving null,
+ *    or values of other types, result in null.
+ *  - Functions: coalesce(value, ...), substr(string, start[, length]) and
+ *    the date parts year, month, day, hour, minute and second of timestamps
+ *    (or epoch seconds)
+ *  - Comparisons: ==, !=, <, <=, >, >=. Values of different types are never
+ *    equal, and only numbers and strings are ordered.
+ *  - Logical operators: &&, || and !, with parentheses for grouping. Values
+ *    other than false, null, zero and the empty string are true.
+ */
+
+// A parsed expression, evaluated against each record.
+type expression interface {
+	eval(record map[string]interface{}) interface{}
+}
+
+type literalExpr struct {
+	value interface{}
+}
+
+type propertyExpr struct {
+	name string
+}
+
+type notExpr struct {
+	operand expression
+}
+
+type negateExpr struct {
+	operand expression
+}
+
+type binaryExpr struct {
+	op    string
+	left  expression
+	right expression
+}
+
+type callExpr struct {
+	fn   string
+	args []expression
+}
+
+// Functions of expressions, and their minimum and maximum numbers of
+// arguments (or -1, for any number).
+var expression_functions = map[string][2]int{
+	"coalesce": {1, -1},
+	"substr":   {2, 3},
+	"year":     {1, 1},
+	"month":    {1, 1},
+	"day":      {1, 1},
+	"hour":     {1, 1},
+	"minute":   {1, 1},
+	"second":   {1, 1},
+}
+
+func (e literalExpr) eval(record map[string]interface{}) interface{} {
+	return e.value
+}
+
+func (e propertyExpr) eval(record map[string]interface{}) interface{} {
+	return record[e.name]
+}
+
+func (e notExpr) eval(record map[string]interface{}) interface{} {
+	return truthy(e.operand.eval(record)) == false
+}
+
+func (e negateExpr) eval(record map[string]interface{}) interface{} {
+	if n, ok := e.operand.eval(record).(float64); ok == true {
+		return -n
+	}
+	return nil
+}
+
+func (e binaryExpr) eval(record map[string]interface{}) interface{} {
+	switch e.op {
+	case "&&":
+		return truthy(e.left.eval(record)) && truthy(e.right.eval(record))
+	case "||":
+		return truthy(e.left.eval(record)) || truthy(e.right.eval(record))
+	}
+
+	left, right := e.left.eval(record), e.right.eval(record)
+	switch e.op {
+	case "+", "-", "*", "/", "%":
+		return arithmetic(e.op, left, right)
+	}
+
+	order, ok := compareValues(left, right)
+	switch e.op {
+	case "==":
+		return ok && order == 0
+	case "!=":
+		return ok == false || order != 0
+	}
+
+	// Only numbers and strings are ordered
+	if _, isBool := left.(bool); ok == false || isBool || left == nil {
+		return false
+	}
+	switch e.op {
+	case "<":
+		return order < 0
+	case "<=":
+		return order <= 0
+	case ">":
+		return order > 0
+	}
+	return order >= 0
+}
+
+func (e callExpr) eval(record map[string]interface{}) interface{} {
+	args := make([]interface{}, len(e.args))
+	for i, arg := range e.args {
+		args[i] = arg.eval(record)
+	}
+
+	switch e.fn {
+	case "coalesce":
+		for _, arg := range args {
+			if arg != nil {
+				return arg
+			}
+		}
+		return nil
+	case "substr":
+		return substr(args)
+	}
+
+	// Date parts
+	var t time.Time
+	switch v := args[0].(type) {
+	case string:
+		var ok bool
+		if t, ok = parseTimestamp(v); ok == false {
+			return nil
+		}
+	case float64:
+		t = epochTime(v, EpochSeconds)
+	default:
+		return nil
+	}
+	parts := map[string]int{
+		"year":   t.Year(),
+		"month":  int(t.Month()),
+		"day":    t.Day(),
+		"hour":   t.Hour(),
+		"minute": t.Minute(),
+		"second": t.Second(),
+	}
+	return float64(parts[e.fn])
+}
+
+// Applies an arithmetic operator. Adding a string to any scalar concatenates
+// them.
+func arithmetic(op string, left interface{}, right interface{}) interface{} {
+	x, leftNumber := left.(float64)
+	y, rightNumber := right.(float64)
+	if leftNumber && rightNumber {
+		switch op {
+		case "+":
+			return x + y
+		case "-":
+			return x - y
+		case "*":
+			return x * y
+		case "/":
+			if y != 0 {
+				return x / y
+			}
+		case "%":
+			if y != 0 {
+				return math.Mod(x, y)
+			}
+		}
+		return nil
+	}
+
+	_, leftString := left.(string)
+	_, rightString := right.(string)
+	if op == "+" && (leftString || rightString) && left != nil && right != nil {
+		a, aok := concatenable(left)
+		b, bok := concatenable(right)
+		if aok && bok {
+			return a + b
+		}
+	}
+	return nil
+}
+
+// Returns the text of a scalar value, for concatenation.
+func concatenable(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	return "", false
+}
+
+// Returns the substring of a string (its first argument) from a start index
+// (in characters, from zero) and of an optional length.
+func substr(args []interface{}) interface{} {
+	s, ok := args[0].(string)
+	start, startOk := args[1].(float64)
+	if ok == false || startOk == false {
+		return nil
+	}
+	runes := []rune(s)
+	from := int(math.Max(0, math.Min(start, float64(len(runes)))))
+	to := len(runes)
+	if len(args) > 2 {
+		length, ok := args[2].(float64)
+		if ok == false {
+			return nil
+		}
+		to = from + int(math.Max(0, math.Min(length, float64(len(runes)-from))))
+	}
+	return string(runes[from:to])
+}
+
+// A column computed from the other properties of each record.
+type DerivedColumn struct {
+	Name string
+	// Expression computing the column's values (eg. `first_name + " " +
+	// last_name`)
+	Expression string
+}
+
+type derivation struct {
+	name string
+	expr expression
+}
+
+// Parses the projection, filter and derived columns given in the options.
+func (c *converter) parseExpressions(opts Options) error {
+	var err error
+	if c.projection, err = parseProjection(opts.Projection); err != nil {
+		return err
+	}
+	if c.filter, err = parseFilter(opts.Filter); err != nil {
+		return err
+	}
+	c.derived = make([]derivation, len(opts.DerivedColumns))
+	for i, col := range opts.DerivedColumns {
+		if col.Name == "" {
+			return fmt.Errorf("derived columns must have a name")
+		}
+		subject := fmt.Sprintf("derived column '%s'", col.Name)
+		expr, err := parseExpression(col.Expression, subject)
+		if err != nil {
+			return err
+		}
+		c.derived[i] = derivation{col.Name, expr}
+	}
+	return nil
+}
+
+// Adds the values of derived columns to a record, in order, so each can use
+// those before it. Values replace any property of the same name.
+func (c *converter) derive(record map[string]interface{}) {
+	for _, d := range c.derived {
+		record[d.name] = d.expr.eval(record)
+	}
+}
+
+// Reports whether a record is selected by a filter. Without a filter, all
+// records are.
+func (c *converter) selects(record map[string]interface{}) bool {
+	return c.filter == nil || truthy(c.filter.eval(record))
+}
+
+// Reports whether a value counts as true in a filter.
+func truthy(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	}
+	return true
+}
+
+// Compares two scalar values of the same type, returning their order. Reports
+// false when the values have different types, or are not scalars.
+func compareValues(a interface{}, b interface{}) (int, bool) {
+	switch x := a.(type) {
+	case nil:
+		return 0, b == nil
+	case bool:
+		if y, ok := b.(bool); ok == true {
+			if x == y {
+				return 0, true
+			}
+			return 1, true
+		}
+	case float64:
+		if y, ok := b.(float64); ok == true {
+			switch {
+			case x < y:
+				return -1, true
+			case x > y:
+				return 1, true
+			}
+			return 0, true
+		}
+	case string:
+		if y, ok := b.(string); ok == true {
+			return strings.Compare(x, y), true
+		}
+	}
+	return 0, false
+}
+
+// Parses a filter expression. An empty expression is no filter at all.
+func parseFilter(text string) (expression, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, nil
+	}
+	return parseExpression(text, "filter")
+}
+
+// Parses an expression. Errors are described as those of the given subject
+// (eg. "filter").
+func parseExpression(text string, subject string) (expression, error) {
+	p := &expressionParser{input: text, subject: subject}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.kind != end_token {
+		return nil, p.unexpected()
+	}
+	return expr, nil
+}
+
+// Kinds of tokens in expressions.
+const (
+	end_token = iota
+	name_token
+	string_token
+	number_token
+	operator_token
+)
+
+// Parses expressions by recursive descent, one token at a time.
+type expressionParser struct {
+	input   string
+	subject string
+	pos     int
+
+	// Current token, and its position
+	kind  int
+	text  string
+	value interface{}
+	start int
+}
+
+func (p *expressionParser) parseOr() (expression, error) {
+	return p.parseBinary(p.parseAnd, "||")
+}
+
+func (p *expressionParser) parseAnd() (expression, error) {
+	return p.parseBinary(p.parseNot, "&&")
+}
+
+func (p *expressionParser) parseNot() (expression, error) {
+	if p.kind == operator_token && p.text == "!" {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		operand, err := p.parseNot()
+		return notExpr{operand}, err
+	}
+	return p.parseComparison()
+}
+
+func (p *expressionParser) parseComparison() (expression, error) {
+	left, err := p.parseSum()
+	if err != nil || p.kind != operator_token {
+		return left, err
+	}
+	switch op := p.text; op {
+	case "==", "!=", "<", "<=", ">", ">=":
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		return binaryExpr{op, left, right}, nil
+	}
+	return left, nil
+}
+
+func (p *expressionParser) parseSum() (expression, error) {
+	return p.parseBinary(p.parseProduct, "+", "-")
+}
+
+func (p *expressionParser) parseProduct() (expression, error) {
+	return p.parseBinary(p.parseUnary, "*", "/", "%")
+}
+
+// Parses a left-associative chain of operands joined by any of the given
+// operators.
+func (p *expressionParser) parseBinary(operand func() (expression, error), ops ...string) (expression, error) {
+	left, err := operand()
+	for err == nil && p.kind == operator_token {
+		op := ""
+		for _, candidate := range ops {
+			if p.text == candidate {
+				op = candidate
+			}
+		}
+		if op == "" {
+			break
+		}
+		var right expression
+		if err = p.next(); err == nil {
+			if right, err = operand(); err == nil {
+				left = binaryExpr{op, left, right}
+			}
+		}
+	}
+	return left, err
+}
+
+func (p *expressionParser) parseUnary() (expression, error) {
+	if p.kind == operator_token && p.text == "-" {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		operand, err := p.parseUnary()
+		return negateExpr{operand}, err
+	}
+	return p.parseOperand()
+}
+
+func (p *expressionParser) parseOperand() (expression, error) {
+	var expr expression
+	switch p.kind {
+	case string_token, number_token:
+		expr = literalExpr{p.value}
+	case name_token:
+		switch p.text {
+		case "true":
+			expr = literalExpr{true}
+		case "false":
+			expr = literalExpr{false}
+		case "null":
+			expr = literalExpr{nil}
+		default:
+			expr = propertyExpr{p.text}
+		}
+		if p.value == nil && strings.HasPrefix(strings.TrimLeft(p.input[p.pos:], " \t\r\n"), "(") {
+			return p.parseCall()
+		}
+	case operator_token:
+		if p.text != "(" {
+			return nil, p.unexpected()
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.kind != operator_token || p.text != ")" {
+			return nil, p.unexpected()
+		}
+		expr = inner
+	default:
+		return nil, p.unexpected()
+	}
+	return expr, p.next()
+}
+
+// Parses a function call, from the function's name.
+func (p *expressionParser) parseCall() (expression, error) {
+	call := callExpr{fn: p.text}
+	arity, ok := expression_functions[call.fn]
+	if ok == false {
+		return nil, fmt.Errorf("malformed %s: unknown function '%s' at position %d", p.subject, call.fn, p.start+1)
+	}
+	start := p.start
+
+	// Opening parenthesis
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	for p.kind != operator_token || p.text != ")" {
+		if len(call.args) > 0 {
+			if p.kind != operator_token || p.text != "," {
+				return nil, p.unexpected()
+			}
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		call.args = append(call.args, arg)
+	}
+	if len(call.args) < arity[0] || (arity[1] >= 0 && len(call.args) > arity[1]) {
+		return nil, fmt.Errorf("malformed %s: wrong number of arguments to '%s' at position %d", p.subject, call.fn, start+1)
+	}
+	return call, p.next()
+}
+
+// Reads the next token.
+func (p *expressionParser) next() error {
+	for p.pos < len(p.input) && strings.IndexByte(" \t\r\n", p.input[p.pos]) >= 0 {
+		p.pos++
+	}
+	p.start, p.value = p.pos, nil
+	if p.pos >= len(p.input) {
+		p.kind, p.text = end_token, ""
+		return nil
+	}
+
+	rest := p.input[p.pos:]
+	r, _ := utf8.DecodeRuneInString(rest)
+	switch {
+	case r == '"' || r == '\'' || r == '`':
+		// Strings, or quoted property names
+		end := strings.IndexRune(rest[1:], r)
+		if end < 0 {
+			return fmt.Errorf("malformed %s: unterminated %c at position %d", p.subject, r, p.pos+1)
+		}
+		p.kind, p.text, p.value = string_token, rest[1:end+1], rest[1:end+1]
+		if r == '`' {
+			// Quoted names are never keywords or functions
+			p.kind = name_token
+		}
+		p.pos += end + 2
+		return nil
+	case r == '.' || unicode.IsDigit(r):
+		end := 1
+		for end < len(rest) && strings.IndexByte("0123456789.eE+-", rest[end]) >= 0 {
+			if (rest[end] == '+' || rest[end] == '-') && strings.IndexByte("eE", rest[end-1]) < 0 {
+				break
+			}
+			end++
+		}
+		n, err := strconv.ParseFloat(rest[:end], 64)
+		if err != nil {
+			return fmt.Errorf("malformed %s: invalid number '%s' at position %d", p.subject, rest[:end], p.pos+1)
+		}
+		p.kind, p.text, p.value = number_token, rest[:end], n
+		p.pos += end
+		return nil
+	case r == '_' || unicode.IsLetter(r):
+		end := strings.IndexFunc(rest, func(r rune) bool {
+			return r != '_' && r != '.' && unicode.IsLetter(r) == false && unicode.IsDigit(r) == false
+		})
+		if end < 0 {
+			end = len(rest)
+		}
+		p.kind, p.text = name_token, rest[:end]
+		p.pos += end
+		return nil
+	}
+
+	for _, op := range []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")", "+", "-", "*", "/", "%", ","} {
+		if strings.HasPrefix(rest, op) {
+			p.kind, p.text = operator_token, op
+			p.pos += len(op)
+			return nil
+		}
+	}
+	return fmt.Errorf("malformed %s: unexpected '%c' at position %d", p.subject, r, p.pos+1)
+}
+
+func (p *expressionParser) unexpected() error {
+	if p.kind == end_token {
+		return fmt.Errorf("malformed %s: unexpected end of expression", p.subject)
+	}
+	return fmt.Errorf("malformed %s: unexpected '%s' at position %d", p.subject, p.text, p.start+1)
+}
@@ -0,0 +1,204 @@
+package fjson2csv
+
+import (
+	"testing"
+)
+
+func TestFilterExpressions(t *testing.T) {
+	t.Parallel()
+
+	record := map[string]interface{}{
+		"status":     "active",
+		"age":        float64(42),
+		"admin":      false,
+		"note":       nil,
+		"user.name":  "jane",
+		"first name": "Jane",
+		"tags":       []interface{}{"a"},
+	}
+
+	cases := []struct {
+		expression string
+		expected   bool
+	}{
+		{`status == "active"`, true},
+		{`status == 'active' && age > 30`, true},
+		{`status != "active" || age >= 43`, false},
+		{`age < 42.5 && age <= 42 && age > -1e3`, true},
+		{`admin`, false},
+		{`!admin && !(age < 30)`, true},
+		{`note == null && missing == null`, true},
+		{`note`, false},
+		{`user.name == "jane"`, true},
+		{"`first name` == \"Jane\"", true},
+		{`age == "42"`, false},
+		{`age != "42"`, true},
+		{`status > 5`, false},
+		{`admin < true`, false},
+		{`status > "abc"`, true},
+		{`tags`, true},
+		{`tags == tags`, false},
+		{`age == 40 || age == 41 || age == 42 && admin == false`, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.expression, func(t *testing.T) {
+			expr, err := parseFilter(tc.expression)
+			if err != nil {
+				t.Fatalf("parse failure: %s", err.Error())
+			}
+			if found := truthy(expr.eval(record)); found != tc.expected {
+				t.Errorf("expected %t, found %t", tc.expected, found)
+			}
+		})
+	}
+}
+
+func TestFilterParseFailure(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		expression string
+		expected   string
+	}{
+		{`age >`, "malformed filter: unexpected end of expression"},
+		{`(age > 1`, "malformed filter: unexpected end of expression"},
+		{`age > 1)`, "malformed filter: unexpected ')' at position 8"},
+		{`age = 1`, "malformed filter: unexpected '=' at position 5"},
+		{`status == "active`, "malformed filter: unterminated \" at position 11"},
+		{`age > 1.2.3`, "malformed filter: invalid number '1.2.3' at position 7"},
+		{`age age`, "malformed filter: unexpected 'age' at position 5"},
+		{`nothing(age)`, "malformed filter: unknown function 'nothing' at position 1"},
+		{`substr(name)`, "malformed filter: wrong number of arguments to 'substr' at position 1"},
+		{`year(created, 1)`, "malformed filter: wrong number of arguments to 'year' at position 1"},
+		{`coalesce(a b)`, "malformed filter: unexpected 'b' at position 12"},
+		{`coalesce(a,`, "malformed filter: unexpected end of expression"},
+		{`age + * 2`, "malformed filter: unexpected '*' at position 7"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.expression, func(t *testing.T) {
+			_, err := parseFilter(tc.expression)
+			if err == nil || err.Error() != tc.expected {
+				t.Errorf("expected error '%s', found: %v", tc.expected, err)
+			}
+		})
+	}
+
+	if expr, err := parseFilter("  "); expr != nil || err != nil {
+		t.Errorf("expected no filter for an empty expression")
+	}
+}
+
+func TestExpressionValues(t *testing.T) {
+	t.Parallel()
+
+	record := map[string]interface{}{
+		"first":   "Jane",
+		"last":    "Doe",
+		"price":   float64(12.5),
+		"qty":     float64(4),
+		"active":  true,
+		"created": "2024-03-01T12:34:56Z",
+		"epoch":   float64(1709294400),
+		"note":    nil,
+		"tags":    []interface{}{"a"},
+		"name":    "Zoë Smith",
+	}
+
+	cases := []struct {
+		expression string
+		expected   interface{}
+	}{
+		{`first + " " + last`, "Jane Doe"},
+		{`first + " " + missing`, nil},
+		{`first + qty + active`, "Jane4true"},
+		{`price * qty - 10 / 4`, float64(47.5)},
+		{`(price + 0.5) * -qty`, float64(-52)},
+		{`qty % 3`, float64(1)},
+		{`qty / 0`, nil},
+		{`price + active`, nil},
+		{`first - last`, nil},
+		{`first + tags`, nil},
+		{`-first`, nil},
+		{`coalesce(note, missing, last, first)`, "Doe"},
+		{`coalesce(note)`, nil},
+		{`substr(name, 0, 3)`, "Zoë"},
+		{`substr(name, 4)`, "Smith"},
+		{`substr(name, 7, 100)`, "th"},
+		{`substr(name, 100)`, ""},
+		{`substr(qty, 1)`, nil},
+		{`year(created) * 100 + month(created)`, float64(202403)},
+		{`day(created) + hour(created) + minute(created) + second(created)`, float64(1 + 12 + 34 + 56)},
+		{`hour(epoch)`, float64(12)},
+		{`year(first)`, nil},
+		{`qty * 2 > 7 && first + last == "JaneDoe"`, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.expression, func(t *testing.T) {
+			expr, err := parseExpression(tc.expression, "expression")
+			if err != nil {
+				t.Fatalf("parse failure: %s", err.Error())
+			}
+			if found := expr.eval(record); found != tc.expected {
+				t.Errorf("expected %v, found %v", tc.expected, found)
+			}
+		})
+	}
+}
+
+func TestDerivedConvert(t *testing.T) {
+	t.Parallel()
+
+	raw := `[
+		{"first_name":"Jane", "last_name":"Doe", "price":2.5, "qty":4},
+		{"first_name":"John", "price":1, "qty":3},
+		{"first_name":"Jo", "last_name":"Li"}
+	]`
+
+	cases := []conversionCase{
+		{
+			"derived",
+			Options{DerivedColumns: []DerivedColumn{
+				{"full_name", `first_name + " " + coalesce(last_name, "")`},
+				{"total", `price * qty`},
+			}},
+			"first_name,full_name,total,last_name,price,qty\n" +
+				"Jane,Jane Doe,10,Doe,2.5,4\n" +
+				"John,John ,3,,1,3\n" +
+				"Jo,Jo Li,,Li,,\n",
+			false,
+		},
+		{
+			"chained and filtered",
+			Options{
+				DerivedColumns: []DerivedColumn{{"total", `price * qty`}, {"big", `total >= 10`}},
+				Filter:         `big`,
+			},
+			"big,first_name,total,last_name,price,qty\ntrue,Jane,10,Doe,2.5,4\n",
+			false,
+		},
+		{"replacing", Options{DerivedColumns: []DerivedColumn{{"qty", `coalesce(qty, 0)`}}}, "first_name,qty,last_name,price\nJane,4,Doe,2.5\nJohn,3,,1\nJo,0,Li,\n", false},
+		{"malformed", Options{DerivedColumns: []DerivedColumn{{"total", `price *`}}}, "", true},
+		{"unnamed", Options{DerivedColumns: []DerivedColumn{{"", `price`}}}, "", true},
+	}
+	checkConversions(t, raw, cases)
+}
+
+func TestFilterConvert(t *testing.T) {
+	t.Parallel()
+
+	raw := `[
+		{"name":"Jane", "status":"active", "age":31},
+		{"name":"John", "status":"inactive", "age":45, "reason":"moved"},
+		{"name":"Jo", "status":"active", "age":29}
+	]`
+
+	cases := []conversionCase{
+		{"unfiltered", Options{}, "age,name,status,reason\n31,Jane,active,\n45,John,inactive,moved\n29,Jo,active,\n", false},
+		{"filtered", Options{Filter: `status == "active"`}, "age,name,status,reason\n31,Jane,active,\n29,Jo,active,\n", false},
+		{"filtered columns", Options{Filter: `status == "active"`, FilterColumns: true}, "age,name,status\n31,Jane,active\n29,Jo,active\n", false},
+		{"nothing selected", Options{Filter: `age > 100`, FilterColumns: true}, "", false},
+		{"schema", Options{Filter: `age < 30 || reason != null`, Schema: []Column{{Name: "name"}, {Name: "age", Type: IntegerColumn}}}, "name,age\nJohn,45\nJo,29\n", false},
+		{"malformed", Options{Filter: `age >`}, "", true},
+	}
+	checkConversions(t, raw, cases)
+}
@@ -5,22 +5,29 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"os"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 /*
  * The following assumptions are made when converting JSON input:
  *
- *  - Input JSON is a single collection (array) of objects
+ *  - Input JSON is a single collection (array) of objects, either at the
+ *    root of the document or at the location given by `Options.Path`
+ *  - Alternatively, input JSON is a single object whose members are objects,
+ *    when `Options.KeyColumn` is given
+ *  - Alternatively, input is NDJSON, MessagePack, CBOR or YAML records
+ *    (`Options.InputFormat`)
  *  - Each object contains only properties with scalar values
  *    (no nested objects)
  *  - No expected consistency of property names from object to object
  *    (eg. no fixed schema)
- *  - No string values of properties contain a CSV delimiter
- *    (a comma, by default)
  *  - CSV headers are always included
+ *  - CSV fields are quoted as needed (eg. when they contain a comma, quote
+ *    or line break)
  *  - All properties are included in CSV output, even if an object is
  *    missing them
  *  - CSV fields are sorted by their frequency, then alphabetically
@@ -30,20 +37,52 @@ const default_delimiter string = ","
 const default_write_buffer_size int = 1024
 const default_read_buffer_size int = 1024
 
+// Supported output formats.
+const (
+	CsvFormat      string = "csv"
+	XlsxFormat     string = "xlsx"
+	ParquetFormat  string = "parquet"
+	SqliteFormat   string = "sqlite"
+	SqlFormat      string = "sql"
+	MarkdownFormat string = "markdown"
+	HtmlFormat     string = "html"
+)
+
 // Converts JSON into CSV incrementally.
 func UnbufferedConvert(r io.ReadSeeker, w io.Writer, opts Options) error {
-	rsize, wsize := getBufferSizes(opts)
-	c := converter{
-		Source:      r,
-		Destination: w,
-		Keys:        map[string]int64{},
-		delimiter:   default_delimiter,
-		sorted:      []string{},
-		readSize:    rsize,
-		writeSize:   wsize,
+	c := newConverter(r, w, opts)
+	enc, err := newEncoder(opts, w, c.writeSize)
+	if err != nil {
+		return err
+	}
+	if c.input, err = newRecordSource(r, opts); err != nil {
+		return err
+	}
+	if err = c.parseExpressions(opts); err != nil {
+		return err
 	}
-	c.IndexFields(extractKeys)
-	c.WriteCsv(writeRecord)
+	if c.dedup, err = newDeduplicator(opts); err != nil {
+		return err
+	}
+	if c.order, err = newSorter(opts); err != nil {
+		return err
+	}
+	if c.sample, err = newSampler(opts); err != nil {
+		return err
+	}
+	if c.groups, err = newAggregator(opts); err != nil {
+		return err
+	}
+	c.chooseSample()
+	if c.groups != nil {
+		c.aggregate()
+	} else if opts.Schema != nil {
+		c.useSchema(opts)
+		c.findDuplicates()
+	} else {
+		c.IndexFields(extractKeys)
+	}
+	c.WriteRows(enc)
 	if c.err != nil {
 		return c.err
 	}
@@ -52,55 +91,241 @@ func UnbufferedConvert(r io.ReadSeeker, w io.Writer, opts Options) error {
 
 // Converts JSON into CSV in-memory.
 func BufferedConvert(r io.ReadSeeker, w io.Writer, opts Options) error {
-	rsize, wsize := getBufferSizes(opts)
-	c := converter{
-		Source:      r,
-		Destination: w,
-		Keys:        map[string]int64{},
-		buffer:      []map[string]interface{}{},
-		delimiter:   default_delimiter,
-		sorted:      []string{},
-		readSize:    rsize,
-		writeSize:   wsize,
+	c := newConverter(r, w, opts)
+	enc, err := newEncoder(opts, w, c.writeSize)
+	if err != nil {
+		return err
 	}
-
-	c.IndexFields(bufferData)
-	ew := newErrorWriter(c.Destination, c.writeSize)
-
-	// Write field headers
-	ew.write(fmt.Sprintf("%s\n", strings.Join(c.sorted, c.delimiter)))
-
-	// Write buffered data
-	for i := 0; i < len(c.buffer); i++ {
-		record := c.buffer[i]
-		if value, ok := record[c.sorted[0]]; ok == true {
-			ew.write(value)
-		}
-		for _, key := range c.sorted[1:] {
-			var value interface{} = ""
-			if _, ok := record[key]; ok == true {
-				value = record[key]
-			}
-			ew.write(c.delimiter)
-			ew.write(value)
-		}
-		ew.write("\n")
-		if ew.err != nil {
-			c.err = ew.err
-			break
-		}
+	if c.input, err = newRecordSource(r, opts); err != nil {
+		return err
 	}
-	ew.flush()
+	if err = c.parseExpressions(opts); err != nil {
+		return err
+	}
+	if c.dedup, err = newDeduplicator(opts); err != nil {
+		return err
+	}
+	if c.order, err = newSorter(opts); err != nil {
+		return err
+	}
+	if c.sample, err = newSampler(opts); err != nil {
+		return err
+	}
+	if c.groups, err = newAggregator(opts); err != nil {
+		return err
+	}
+	c.chooseSample()
+	if c.groups != nil {
+		c.aggregate()
+	} else if opts.Schema != nil {
+		// Records are written as they are read, so there is nothing to buffer
+		c.useSchema(opts)
+		c.findDuplicates()
+	} else {
+		c.buffer = []map[string]interface{}{}
+		c.IndexFields(bufferData)
+	}
+	c.WriteRows(enc)
 	if c.err != nil {
 		return c.err
 	}
-
 	return nil
 }
 
 type Options struct {
 	ReadBufferSize  int
 	WriteBufferSize int
+
+	// Location of the array of records within the input document, given
+	// either as a JSON Pointer (eg. "/data/items") or a dotted path
+	// (eg. "data.items"). Defaults to the root of the document.
+	Path string
+
+	// Treats the input as an object whose members are records (eg.
+	// `{"u1":{...},"u2":{...}}`) rather than an array. Each member's name is
+	// written to a column with this name.
+	KeyColumn string
+
+	// Input format, one of `JsonFormat` (the default), `NdjsonFormat`,
+	// `MsgpackFormat`, `CborFormat` or `YamlFormat`.
+	InputFormat string
+
+	// Text encoding of binary values in MessagePack, CBOR and YAML input,
+	// either `Base64Encoding` (the default) or `HexEncoding`.
+	BinaryEncoding string
+
+	// Source of records in any other input format. When given,
+	// `InputFormat` is ignored and records are read from the source rather
+	// than the reader passed to the conversion.
+	Source RecordSource
+
+	// Drops duplicate records, keeping either their first (`KeepFirst`) or
+	// last (`KeepLast`) occurrence. Records are duplicates when they have
+	// the same values in `DedupColumns`, or when all their values are the
+	// same if no columns are given. Only records selected by the filter are
+	// compared, and columns not found in any of them are an error.
+	Dedup        string
+	DedupColumns []string
+
+	// Maximum number of distinct records held in memory while finding
+	// duplicates. Beyond it, they are kept in temporary files. Zero keeps all
+	// of them in memory.
+	DedupMemory int
+
+	// Columns the rows are sorted by, in order of precedence. Columns named
+	// with a leading '-' are sorted in descending order. Numeric and boolean
+	// columns are sorted as numbers, others as text, with null values last.
+	SortBy []string
+
+	// Maximum number of records held in memory while sorting. Beyond it, they
+	// are sorted in batches kept in temporary files, then merged.
+	// (default: 100000)
+	SortMemory int
+
+	// Skips the first `Offset` rows of the output, and writes at most `Limit`
+	// rows after them. Rows are counted after filtering, sampling, finding
+	// duplicates and sorting. A zero limit writes every row.
+	Offset int
+	Limit  int
+
+	// Draws a random sample of the records selected by the filter, either
+	// with a probability of `SampleRate` (between 0 and 1) for each record,
+	// or of exactly `SampleSize` records (or all of them, when fewer are
+	// selected). Samples are the same for the same `SampleSeed`.
+	SampleRate float64
+	SampleSize int
+	SampleSeed int64
+
+	// Writes a row for each group of records with the same values in the
+	// `GroupBy` columns, made of those values and the `Aggregates` of the
+	// group's records (by default, their count), rather than the records
+	// themselves. Aggregates without group columns make a single group.
+	GroupBy    []string
+	Aggregates []Aggregate
+
+	// Paths of the values written as columns, with optional aliases, in the
+	// manner of jq (eg. `id, user.name as name, tags[0] as first_tag`).
+	// Columns are those of the projection, in order, rather than discovered
+	// (besides any derived columns), and their paths may reach into nested
+	// objects and arrays. Paths ending at an object or array write it as
+	// JSON text. Filters and derived columns use the projected values.
+	Projection string
+
+	// Expression selecting the records to write (eg. `status == "active" &&
+	// age > 30`). Records are written when it is true for them.
+	Filter string
+
+	// Excludes columns from the output whose fields are only found in
+	// records the filter (or sampling) leaves out. Otherwise, the columns of
+	// a filtered conversion are those of an unfiltered one.
+	FilterColumns bool
+
+	// Columns computed from the properties of each record, in order. They
+	// are indexed along with the properties, so their place among the
+	// columns follows from their frequency, and filters can use them.
+	DerivedColumns []DerivedColumn
+
+	// Output format, one of `CsvFormat` (the default), `XlsxFormat`,
+	// `ParquetFormat`, `SqliteFormat`, `SqlFormat`, `MarkdownFormat` or
+	// `HtmlFormat`. `SqliteFormat` requires a database/sql driver registered
+	// as "sqlite", which this package doesn't import (eg. import
+	// _ "modernc.org/sqlite").
+	Format string
+
+	// Columns of the output, in order, as read by `ReadSchema`. Given a
+	// schema, records are written as they are read rather than indexed first,
+	// so the input is only read once.
+	Schema []Column
+
+	// What to do with values violating the type of their column in
+	// `Schema`: `CoercePolicy` (the default), `ReportPolicy` or `FailPolicy`.
+	// Coercion writes null for missing values and values which can't be
+	// converted, so columns are only written as required (eg. NOT NULL in
+	// SQLite, or REQUIRED in Parquet) under `FailPolicy`.
+	SchemaPolicy string
+
+	// What to do with fields whose values have conflicting types (eg. a
+	// number in some records and a string in others): `StringPolicy` (the
+	// default), `NumberPolicy`, `ReportPolicy` or `FailPolicy`.
+	ConflictPolicy string
+
+	// Conflict policies of individual columns, overriding `ConflictPolicy`.
+	ColumnPolicies map[string]string
+
+	// Destination of violations and conflicts reported by `ReportPolicy`.
+	// Defaults to standard error.
+	ViolationLog io.Writer
+
+	// Text written to CSV and XLSX output for null values (eg. `\N` or
+	// "NULL"), and for properties missing from a record. Both default to an
+	// empty string.
+	NullValue    string
+	MissingValue string
+
+	// Treats empty strings as null values, both when inferring the types of
+	// columns and when writing records.
+	EmptyAsNull bool
+
+	// Text written to CSV, XLSX, Markdown and HTML output for the values of
+	// boolean columns (eg. "1" and "0", or "Y" and "N"). Default to "true"
+	// and "false".
+	TrueValue  string
+	FalseValue string
+
+	// Reformats timestamps with this layout (as given to `time.Format`), in
+	// `TimeZone`. Given either, string fields whose values were all
+	// timestamps or dates while indexing (eg. RFC 3339 strings) are
+	// reformatted. The layout defaults to RFC 3339, and the zone to UTC.
+	TimeLayout string
+	TimeZone   *time.Location
+
+	// Columns of numeric timestamps, and the units of their numbers:
+	// `EpochSeconds`, `EpochMilliseconds` or `EpochAuto`, for columns mixing
+	// both. Their numbers (and any string timestamps) are written as
+	// timestamps in `TimeLayout`.
+	EpochColumns map[string]string
+
+	// Formats the values of CSV, XLSX, Markdown and HTML output, for
+	// formatting beyond the defaults (eg. currencies, enumerations or
+	// masking).
+	Formatter Formatter
+
+	// Encoder for any other output format. When given, `Format` is ignored
+	// and records are written to the encoder rather than the destination
+	// writer passed to the conversion.
+	Encoder RowEncoder
+
+	// Maximum number of records written to Markdown and HTML tables, for
+	// previewing larger inputs. Zero writes all records.
+	PreviewRows int
+
+	// Maximum number of records in each Parquet row group.
+	RowGroupSize int
+
+	// Name of the table records are written to in database output formats
+	// (default: "records").
+	Table string
+
+	// Number of records inserted per transaction in database output formats,
+	// or per INSERT statement in SQL scripts.
+	BatchSize int
+
+	// SQL dialect of SQL scripts, either `PostgresDialect` (the default) or
+	// `MysqlDialect`.
+	Dialect string
+
+	// Loads records in SQL scripts with a `COPY ... FROM stdin` block rather
+	// than INSERT statements. Only supported by Postgres.
+	Copy bool
+
+	// JSON types ("string", "integer", "number" or "boolean") of CSV columns,
+	// by column name, for restoring typed values when converting CSV back to
+	// JSON. Columns are strings by default.
+	ColumnTypes map[string]string
+
+	// Nests values of dotted CSV columns (eg. "user.name") inside objects
+	// when converting CSV back to JSON.
+	Unflatten bool
 }
 
 // Convenience type for cutting down on error checking and type conversion
@@ -138,6 +363,141 @@ func newErrorWriter(writer io.Writer, size int) *errWriter {
 	}
 }
 
+// Writes converted records in an output format.
+//
+// Conversions call `WriteHeader` once with the ordered list of columns, then
+// `WriteRow` for each record and finally `Close`. Records are passed as
+// decoded JSON objects, so properties missing from a record are missing from
+// its map. Encoders are expected to write a record's values in column order.
+type RowEncoder interface {
+	// Begins output, given the ordered list of columns.
+	WriteHeader(columns []Column) error
+	// Writes the values of a single record.
+	WriteRow(record map[string]interface{}) error
+	// Completes output, flushing anything buffered.
+	Close() error
+}
+
+// Creates the encoder for the output format given in the options.
+func newEncoder(opts Options, w io.Writer, size int) (RowEncoder, error) {
+	if opts.Encoder != nil {
+		return opts.Encoder, nil
+	}
+	switch opts.Format {
+	case "", CsvFormat:
+		enc := newCsvEncoder(w, size, default_delimiter)
+		enc.null, enc.missing = opts.NullValue, opts.MissingValue
+		enc.values = newValueFormatter(opts)
+		return enc, nil
+	case XlsxFormat:
+		enc := newXlsxEncoder(w, size)
+		enc.null, enc.missing = opts.NullValue, opts.MissingValue
+		enc.values = newValueFormatter(opts)
+		return enc, nil
+	case ParquetFormat:
+		return newParquetEncoder(w, size, opts.RowGroupSize), nil
+	case SqliteFormat:
+		return newSqliteEncoder(w, opts.Table, opts.BatchSize), nil
+	case SqlFormat:
+		return newSqlEncoder(w, size, opts)
+	case MarkdownFormat:
+		enc := newMarkdownEncoder(w, size, opts.PreviewRows)
+		enc.values = newValueFormatter(opts)
+		return enc, nil
+	case HtmlFormat:
+		enc := newHtmlEncoder(w, size, opts.PreviewRows)
+		enc.values = newValueFormatter(opts)
+		return enc, nil
+	default:
+		return nil, fmt.Errorf("unsupported output format: %s", opts.Format)
+	}
+}
+
+// Writes records as CSV, the default output format.
+type csvEncoder struct {
+	ew        *errWriter
+	delimiter string
+	columns   []Column
+	null      string
+	missing   string
+	values    valueFormatter
+}
+
+// Creates an encoder for CSV output, which is used when no other output
+// format is given.
+func NewCsvEncoder(w io.Writer) RowEncoder {
+	return newCsvEncoder(w, default_write_buffer_size*1000, default_delimiter)
+}
+
+func newCsvEncoder(w io.Writer, size int, delimiter string) *csvEncoder {
+	return &csvEncoder{
+		ew:        newErrorWriter(w, size),
+		delimiter: delimiter,
+	}
+}
+
+// Writes field headers. Without any columns, no CSV is written at all.
+func (e *csvEncoder) WriteHeader(columns []Column) error {
+	e.columns = columns
+	keys := make([]string, len(columns))
+	for i, col := range columns {
+		keys[i] = e.quote(col.Name)
+	}
+	if len(keys) > 0 {
+		e.ew.write(fmt.Sprintf("%s\n", strings.Join(keys, e.delimiter)))
+	}
+	return e.ew.err
+}
+
+// Writes record values according to the column order and delimiter. Values
+// are converted to the types of their columns, null or missing values are
+// written as their tokens, and fields are quoted as needed.
+func (e *csvEncoder) WriteRow(record map[string]interface{}) error {
+	if len(e.columns) == 0 {
+		return e.ew.err
+	}
+
+	for i, col := range e.columns {
+		if i > 0 {
+			e.ew.write(e.delimiter)
+		}
+		value, ok := record[col.Name]
+		if ok == false {
+			e.ew.write(e.quote(e.missing))
+		} else if value = col.Convert(value); value == nil {
+			e.ew.write(e.quote(e.null))
+		} else if text, err := e.values.format(col, value); err != nil {
+			return err
+		} else {
+			e.ew.write(e.quote(text))
+		}
+	}
+
+	// Finish off line
+	e.ew.write("\n")
+
+	return e.ew.err
+}
+
+// Quotes a field the way `encoding/csv` does, when it contains the delimiter,
+// a quote or a line break, or begins with a space. Quotes within the field are
+// doubled.
+func (e *csvEncoder) quote(field string) string {
+	if field == "" {
+		return field
+	}
+	if strings.Contains(field, e.delimiter) == false && strings.ContainsAny(field, "\"\r\n") == false &&
+		field[0] != ' ' && field[0] != '\t' {
+		return field
+	}
+	return `"` + strings.ReplaceAll(field, `"`, `""`) + `"`
+}
+
+func (e *csvEncoder) Close() error {
+	e.ew.flush()
+	return e.ew.err
+}
+
 // Prototype for functions used as callbacks during JSON structure walks.
 type walkFunction func(record map[string]interface{}, args ...interface{}) error
 
@@ -152,55 +512,214 @@ type converter struct {
 	Keys        map[string]int64
 	delimiter   string
 	buffer      []map[string]interface{}
+	dedup       *deduplicator
+	derived     []derivation
+	emptyNull   bool
+	epochs      map[string]string
 	err         error
+	fields      map[string]*fieldStats
+	groups      *aggregator
+	filter      expression
+	input       RecordSource
+	keyColumn   string
+	limit       int
+	log         io.Writer
+	offset      int
+	order       *sorter
+	path        []string
+	policies    map[string]string
+	policy      string
+	projection  projection
+	prune       bool
 	readSize    int
+	records     int64
+	rows        int64
+	sample      *sampler
+	schema      *schemaChecker
 	sorted      []string
+	timeLayout  string
+	timeZone    *time.Location
+	times       map[string]string
+	walked      bool
 	writeSize   int
 }
 
+func newConverter(r io.ReadSeeker, w io.Writer, opts Options) converter {
+	rsize, wsize := getBufferSizes(opts)
+	var log io.Writer = os.Stderr
+	if opts.ViolationLog != nil {
+		log = opts.ViolationLog
+	}
+	return converter{
+		Source:      r,
+		Destination: w,
+		Keys:        map[string]int64{},
+		delimiter:   default_delimiter,
+		emptyNull:   opts.EmptyAsNull,
+		epochs:      opts.EpochColumns,
+		fields:      map[string]*fieldStats{},
+		keyColumn:   opts.KeyColumn,
+		limit:       opts.Limit,
+		log:         log,
+		offset:      opts.Offset,
+		path:        parsePath(opts.Path),
+		policies:    opts.ColumnPolicies,
+		policy:      opts.ConflictPolicy,
+		prune:       opts.FilterColumns,
+		sorted:      []string{},
+		timeLayout:  opts.TimeLayout,
+		timeZone:    opts.TimeZone,
+		readSize:    rsize,
+		writeSize:   wsize,
+	}
+}
+
 // Walks a flat JSON array, invoking the given callback for each object
 // encountered. The callback is passed `map[string]interface{}` deserializaiton
 // of each object.
+//
+// When the converter has a record source, its records are walked instead of
+// the JSON input. The source is reset before every walk but the first.
 func (c *converter) WalkJsonList(fn walkFunction, args ...interface{}) {
-	dec := json.NewDecoder(bufio.NewReaderSize(c.Source, c.readSize))
+	source := c.input
+	if source == nil {
+		source = &jsonSource{
+			r:         c.Source,
+			path:      c.path,
+			keyColumn: c.keyColumn,
+			size:      c.readSize,
+		}
+	}
 
-	// Opening bracket
-	if token, err := dec.Token(); err != nil {
-		c.err = fmt.Errorf("malformed JSON")
-		return
-	} else {
-		delim, ok := token.(json.Delim)
-		if ok == false || delim.String() != "[" {
-			c.err = fmt.Errorf("malformed JSON: document must be an array of objects")
+	// Rewind for another pass
+	if c.walked == true {
+		if err := source.Reset(); err != nil {
+			c.err = err
+			return
 		}
 	}
+	c.walked = true
+	if c.sample != nil {
+		c.sample.rewind()
+	}
 
-	// Scan each record and extract key names and frequencies
-	for dec.More() {
-		var record interface{}
-		if err := dec.Decode(&record); err != nil {
+	for {
+		record, err := source.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
 			c.err = err
 			return
-		} else {
-			m := record.(map[string]interface{})
-			if err := fn(m, args...); err != nil {
-				c.err = err
-				return
+		}
+		values := record.Map()
+		if c.projection != nil {
+			values = c.projection.apply(values)
+		}
+		if c.emptyNull == true {
+			for key, value := range values {
+				if value == "" {
+					values[key] = nil
+				}
 			}
 		}
+		c.derive(values)
+		if err := fn(values, args...); err != nil {
+			c.err = err
+			return
+		}
 	}
+}
 
-	// Closing bracket
-	if _, err := dec.Token(); err != nil {
-		c.err = fmt.Errorf("malformed JSON: array does not end properly")
-		return
+// Advances a decoder to the value found at the given path, skipping over
+// everything that precedes it. Array elements are addressed by index.
+func seekPath(dec *json.Decoder, path []string) error {
+	for depth, segment := range path {
+		notFound := fmt.Errorf("path not found: /%s", strings.Join(path[:depth+1], "/"))
+
+		token, err := dec.Token()
+		if err != nil {
+			return fmt.Errorf("malformed JSON")
+		}
+		delim, ok := token.(json.Delim)
+		if ok == false {
+			return notFound
+		}
+
+		switch delim.String() {
+		case "{":
+			found := false
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return fmt.Errorf("malformed JSON")
+				}
+				if key.(string) == segment {
+					found = true
+					break
+				}
+				if err := skipValue(dec); err != nil {
+					return err
+				}
+			}
+			if found == false {
+				return notFound
+			}
+		case "[":
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 {
+				return notFound
+			}
+			for i := 0; i < index && dec.More(); i++ {
+				if err := skipValue(dec); err != nil {
+					return err
+				}
+			}
+			if dec.More() == false {
+				return notFound
+			}
+		default:
+			return notFound
+		}
+	}
+	return nil
+}
+
+// Consumes the next value from a decoder without deserializing it.
+func skipValue(dec *json.Decoder) error {
+	depth := 0
+	for {
+		token, err := dec.Token()
+		if err != nil {
+			return fmt.Errorf("malformed JSON")
+		}
+		if delim, ok := token.(json.Delim); ok == true {
+			switch delim.String() {
+			case "{", "[":
+				depth++
+			default:
+				depth--
+			}
+		}
+		if depth == 0 {
+			return nil
+		}
 	}
+}
 
-	// Rewind file cursor
-	if _, err := c.Source.Seek(0, 0); err != nil {
-		c.err = fmt.Errorf("file read failure: %s", err.Error())
-		return
+// Splits a JSON Pointer (RFC 6901) or dotted path into its segments.
+func parsePath(path string) []string {
+	if path == "" {
+		return nil
 	}
+	if strings.HasPrefix(path, "/") {
+		segments := strings.Split(path[1:], "/")
+		for i, segment := range segments {
+			segment = strings.Replace(segment, "~1", "/", -1)
+			segments[i] = strings.Replace(segment, "~0", "~", -1)
+		}
+		return segments
+	}
+	return strings.Split(path, ".")
 }
 
 // Extracts all property names from JSON input.
@@ -216,72 +735,140 @@ func (c *converter) IndexFields(fn walkFunction) {
 		i++
 	}
 	sort.Sort(c)
+	if c.projection != nil {
+		c.orderProjection()
+	}
+
+	c.resolveConflicts()
 }
 
-// Writes the CSV version of all data in the JSON input to the
-// converter's writer.
-func (c *converter) WriteCsv(fn walkFunction) {
+// Writes all records to the given encoder, either from the buffer (when
+// converting in-memory) or by walking the JSON input again.
+func (c *converter) WriteRows(enc RowEncoder) {
+	if c.dedup != nil {
+		defer c.dedup.close()
+	}
+	if c.order != nil {
+		defer c.order.close()
+	}
 	if c.err != nil {
 		return
 	}
-	if len(c.sorted) == 0 {
+	if c.err = c.detectTimes(); c.err != nil {
 		return
 	}
-
-	w := newErrorWriter(c.Destination, c.writeSize)
-
-	// Write field headers
-	w.write(fmt.Sprintf("%s\n", strings.Join(c.sorted, c.delimiter)))
-
-	// Write JSON data as CSV
-	c.WalkJsonList(fn, c, w)
-	w.flush()
+	if c.dedup != nil {
+		if c.err = c.dedup.prepare(); c.err != nil {
+			return
+		}
+	}
+	columns := c.columns()
+	if c.order != nil {
+		if c.err = c.order.prepare(columns); c.err != nil {
+			return
+		}
+	}
+	if c.err = enc.WriteHeader(columns); c.err != nil {
+		return
+	}
+	if c.buffer != nil {
+		for _, record := range c.buffer {
+			if c.err = c.writeRow(enc, record); c.err != nil {
+				break
+			}
+		}
+	} else {
+		c.WalkJsonList(writeRecord, c, enc)
+	}
+	if c.order != nil && c.err == nil {
+		c.err = c.order.flush(func(record map[string]interface{}) error {
+			return c.encodeRow(enc, record)
+		})
+	}
+	if c.err == errLimitReached {
+		c.err = nil
+	}
+	if err := enc.Close(); c.err == nil {
+		c.err = err
+	}
 }
 
-// Callback function that indexes record keys.
+// Callback function that indexes record keys and the types of their values.
 func extractKeys(record map[string]interface{}, args ...interface{}) error {
 	c := args[0].(*converter)
-	for key, _ := range record {
+	return c.index(record, c.includes(record))
+}
+
+// Callback function that buffers (included records) and indexes record keys.
+func bufferData(record map[string]interface{}, args ...interface{}) error {
+	c := args[0].(*converter)
+	included := c.includes(record)
+	if included == true {
+		c.buffer = append(c.buffer, record)
+	}
+	return c.index(record, included)
+}
+
+// Indexes the keys of a record. Records left out by the filter or sampling
+// are only indexed when their columns are kept.
+func (c *converter) index(record map[string]interface{}, included bool) error {
+	if included == false && c.prune == true {
+		return nil
+	}
+	if included == true && c.dedup != nil {
+		if err := c.dedup.observe(record); err != nil {
+			return err
+		}
+	}
+	c.records += 1
+	for key, value := range record {
 		if _, ok := c.Keys[key]; ok == false {
 			c.Keys[key] = 0
 		}
 		c.Keys[key] += 1
+		c.observe(key, value)
 	}
 	return nil
 }
 
-// Callback function that buffers and indexes record keys.
-func bufferData(record map[string]interface{}, args ...interface{}) error {
-	c := args[0].(*converter)
-	c.buffer = append(c.buffer, record)
-	return extractKeys(record, args...)
-}
-
-// Callback function which outputs record values to a writer according to the
-// given key map and delimiter.
+// Callback function which outputs a record selected by the filter (and
+// sampling) to an encoder.
 func writeRecord(record map[string]interface{}, args ...interface{}) error {
 	c := args[0].(*converter)
-	w := args[1].(*errWriter)
-
-	// Write first value (for delimiter reasons)
-	if value, ok := record[c.sorted[0]]; ok == true {
-		w.write(value)
+	enc := args[1].(RowEncoder)
+	if c.includes(record) == false {
+		return nil
 	}
+	return c.writeRow(enc, record)
+}
 
-	// Write subsequent values
-	for _, key := range c.sorted[1:] {
-		var value interface{} = ""
-		if _, ok := record[key]; ok == true {
-			value = record[key]
+// Writes a record to an encoder, unless it is a duplicate. Records are held
+// back until the end when rows are sorted.
+func (c *converter) writeRow(enc RowEncoder, record map[string]interface{}) error {
+	if c.dedup != nil {
+		if keep, err := c.dedup.keeps(record); err != nil || keep == false {
+			return err
 		}
-		w.write(c.delimiter)
-		w.write(value)
 	}
+	if c.order != nil {
+		return c.order.add(record)
+	}
+	return c.encodeRow(enc, record)
+}
 
-	// Finish off line
-	w.write("\n")
-
-	return w.err
+// Writes a record to an encoder, first checking it against the schema (if
+// any).
+func (c *converter) encodeRow(enc RowEncoder, record map[string]interface{}) error {
+	if write, err := c.countRow(); err != nil || write == false {
+		return err
+	}
+	c.formatTimes(record)
+	if c.schema != nil {
+		if err := c.schema.check(record); err != nil {
+			return err
+		}
+	}
+	return enc.WriteRow(record)
 }
 
 /*
@@ -26,6 +26,79 @@ func (bs badSeeker) Seek(offset int64, whence int) (int64, error) {
 	return 0, fmt.Errorf("intentional")
 }
 
+// Conversions of JSON input, which tests expect to agree.
+var conversions = map[string]func(io.ReadSeeker, io.Writer, Options) error{
+	"buffered":   BufferedConvert,
+	"unbuffered": UnbufferedConvert,
+}
+
+// A conversion of a test's input, and its expected CSV output.
+type conversionCase struct {
+	name     string
+	opts     Options
+	expected string
+	willFail bool
+}
+
+// Runs each case as a subtest, checking both conversions of the input.
+func checkConversions(t *testing.T, raw string, cases []conversionCase) {
+	t.Helper()
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.willFail == true {
+				checkConversionFails(t, raw, tc.opts)
+			} else {
+				checkConversion(t, raw, tc.opts, tc.expected)
+			}
+		})
+	}
+}
+
+// Checks that both conversions of the input write the expected CSV output.
+func checkConversion(t *testing.T, raw string, opts Options, expected string) {
+	t.Helper()
+	for name, output := range convertBoth(t, raw, opts) {
+		checkOutput(t, name+" conversion", expected, string(output))
+	}
+}
+
+// Checks that both conversions of the input fail.
+func checkConversionFails(t *testing.T, raw string, opts Options) {
+	t.Helper()
+	for name, convert := range conversions {
+		if err := convert(strings.NewReader(raw), &bytes.Buffer{}, opts); err == nil {
+			t.Fatalf("expected %s conversion to fail", name)
+		}
+	}
+}
+
+// Converts the input both buffered and unbuffered, returning the output of
+// each conversion by its name.
+func convertBoth(t *testing.T, raw string, opts Options) map[string][]byte {
+	t.Helper()
+	outputs := map[string][]byte{}
+	for name, convert := range conversions {
+		buffer := bytes.Buffer{}
+		if err := convert(strings.NewReader(raw), &buffer, opts); err != nil {
+			t.Fatalf("%s conversion failure: %s", name, err.Error())
+		}
+		outputs[name] = buffer.Bytes()
+	}
+	return outputs
+}
+
+// Fails a test when its output doesn't match the expected output.
+func checkOutput(t *testing.T, what string, expected string, found string) {
+	t.Helper()
+	if found != expected {
+		t.Logf("%s did not match expected output", what)
+		t.Logf("Expected:\n%s", expected)
+		t.Logf("Found:\n%s", found)
+		t.FailNow()
+	}
+}
+
 func TestBufferedConvert(t *testing.T) {
 	t.Parallel()
 
@@ -126,7 +199,7 @@ func TestKeySort(t *testing.T) {
 	}
 }
 
-func TestWriteRecordCallback(t *testing.T) {
+func TestCsvEncoder(t *testing.T) {
 	t.Parallel()
 
 	/*
@@ -135,11 +208,6 @@ func TestWriteRecordCallback(t *testing.T) {
 	 * that stops writing after a few bytes.
 	 */
 
-	c := converter{
-		sorted:    []string{"name", "category", "age", "valid"},
-		delimiter: ",",
-	}
-
 	cases := []struct {
 		name     string
 		expected string
@@ -166,7 +234,15 @@ func TestWriteRecordCallback(t *testing.T) {
 	}
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
-			err := writeRecord(tc.record, &c, tc.writer)
+			enc := &csvEncoder{
+				ew:        tc.writer,
+				delimiter: ",",
+				columns:   []Column{{Name: "name"}, {Name: "category"}, {Name: "age"}, {Name: "valid"}},
+			}
+			err := enc.WriteRow(tc.record)
+			if err == nil {
+				err = enc.Close()
+			}
 			if err != nil && tc.willFail == false {
 				t.Errorf("failed to write CSV data")
 			}
@@ -237,14 +313,14 @@ func TestWriteCSV(t *testing.T) {
 	}
 
 	// Simulate prior error
-	c.WriteCsv(writeRecord)
+	c.WriteRows(newCsvEncoder(&buffer, default_write_buffer_size, ","))
 	if buffer.String() != "" {
 		t.Errorf("expected zero output when converter indicates an error")
 	}
 
 	// Simulate failed indexing
 	c.err = nil
-	c.WriteCsv(writeRecord)
+	c.WriteRows(newCsvEncoder(&buffer, default_write_buffer_size, ","))
 	if buffer.String() != "" {
 		t.Errorf("expected zero output when converter failed indexing")
 	}
@@ -255,7 +331,7 @@ func TestWriteCSV(t *testing.T) {
 12,
 `
 	c.sorted = []string{"example", "test"}
-	c.WriteCsv(writeRecord)
+	c.WriteRows(newCsvEncoder(&buffer, default_write_buffer_size, ","))
 	if buffer.String() != expected {
 		t.Logf("accurate CSV conversion unsuccessful")
 		t.Logf("Expected:\n%s", expected)
@@ -320,6 +396,7 @@ func TestWalkJsonList(t *testing.T) {
 		{"malformed json", io.ReadSeeker(strings.NewReader(`test":1}]`)), fnSucceed, true},
 		{"malformed open bracket", io.ReadSeeker(strings.NewReader(`{"test":1}]`)), fnSucceed, true},
 		{"malformed close bracket", io.ReadSeeker(strings.NewReader(`[{"test":1}`)), fnSucceed, true},
+		{"non-object record", io.ReadSeeker(strings.NewReader(`[{"test":1}, 2]`)), fnSucceed, true},
 		{"bad seek", badSeeker{strings.NewReader(`[{"test":1}]`)}, fnSucceed, true},
 		{"bad callback", io.ReadSeeker(strings.NewReader(`[{"test":1}]`)), fnFail, true},
 		{"success", io.ReadSeeker(strings.NewReader(`[{"test":1}]`)), fnSucceed, false},
@@ -336,6 +413,201 @@ func TestWalkJsonList(t *testing.T) {
 	}
 }
 
+func TestWalkJsonListPath(t *testing.T) {
+	t.Parallel()
+
+	raw := `{
+		"meta": {"count": 2, "links": [{"next": null}]},
+		"pages": [[{"skip":true}], [{"id":1}, {"id":2}]],
+		"data": [{"id":1}, {"id":2}]
+	}`
+
+	cases := []struct {
+		name     string
+		path     string
+		expected int
+		willFail bool
+	}{
+		{"pointer", "/data", 2, false},
+		{"dotted", "data", 2, false},
+		{"array index", "/pages/1", 2, false},
+		{"missing key", "/records", 0, true},
+		{"missing index", "/pages/2", 0, true},
+		{"not an array", "/meta", 0, true},
+		{"through a scalar", "/meta/count/total", 0, true},
+		{"root", "", 0, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			count := 0
+			c := converter{
+				Source: strings.NewReader(raw),
+				path:   parsePath(tc.path),
+			}
+			c.WalkJsonList(func(r map[string]interface{}, args ...interface{}) error {
+				count++
+				return nil
+			})
+			if c.err != nil && tc.willFail == false {
+				t.Errorf("unexpected failure: %s", c.err.Error())
+			}
+			if c.err == nil && tc.willFail == true {
+				t.Errorf("expected failure")
+			}
+			if count != tc.expected {
+				t.Errorf("expected %d records, found %d", tc.expected, count)
+			}
+		})
+	}
+}
+
+func TestWalkJsonListKeyed(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		raw      string
+		expected []string
+		willFail bool
+	}{
+		{"keyed", `{"u1":{"name":"a"}, "u2":{}}`, []string{"u1", "u2"}, false},
+		{"empty", `{}`, []string{}, false},
+		{"array", `[{"name":"a"}]`, []string{}, true},
+		{"non-object record", `{"u1":{"name":"a"}, "u2":3}`, []string{"u1"}, true},
+		{"key conflict", `{"u1":{"id":"a"}}`, []string{}, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ids := []string{}
+			c := converter{
+				Source:    strings.NewReader(tc.raw),
+				keyColumn: "id",
+			}
+			c.WalkJsonList(func(r map[string]interface{}, args ...interface{}) error {
+				ids = append(ids, r["id"].(string))
+				return nil
+			})
+			if c.err != nil && tc.willFail == false {
+				t.Errorf("unexpected failure: %s", c.err.Error())
+			}
+			if c.err == nil && tc.willFail == true {
+				t.Errorf("expected failure")
+			}
+			if strings.Join(ids, ",") != strings.Join(tc.expected, ",") {
+				t.Errorf("expected records %v, found %v", tc.expected, ids)
+			}
+		})
+	}
+}
+
+func TestKeyedConvert(t *testing.T) {
+	t.Parallel()
+
+	raw := `{"data": {
+		"u1": {"name": "Jane", "age": 31},
+		"u2": {"name": "John"}
+	}}`
+	expected := "id,name,age\nu1,Jane,31\nu2,John,\n"
+	opts := Options{Path: "data", KeyColumn: "id"}
+
+	checkConversion(t, raw, opts, expected)
+}
+
+func TestNullValues(t *testing.T) {
+	t.Parallel()
+
+	raw := `[
+		{"name": "Jane", "age": 31, "note": null},
+		{"name": "", "age": null},
+		{"name": "Jo", "note": "x"}
+	]`
+
+	cases := []struct {
+		name     string
+		opts     Options
+		expected string
+	}{
+		{"default", Options{}, "name,age,note\nJane,31,\n,,\nJo,,x\n"},
+		{"null token", Options{NullValue: `\N`}, "name,age,note\nJane,31,\\N\n,\\N,\nJo,,x\n"},
+		{"null and missing tokens", Options{NullValue: "NULL", MissingValue: "MISSING"}, "name,age,note\nJane,31,NULL\n,NULL,MISSING\nJo,MISSING,x\n"},
+		{"empty as null", Options{NullValue: "NULL", MissingValue: "MISSING", EmptyAsNull: true}, "name,age,note\nJane,31,NULL\nNULL,NULL,MISSING\nJo,MISSING,x\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			checkConversion(t, raw, tc.opts, tc.expected)
+		})
+	}
+}
+
+// Records the calls made to an encoder.
+type recordingEncoder struct {
+	log []string
+}
+
+func (e *recordingEncoder) WriteHeader(columns []Column) error {
+	names := []string{}
+	for _, col := range columns {
+		names = append(names, fmt.Sprintf("%s:%d", col.Name, col.Type))
+	}
+	e.log = append(e.log, "header "+strings.Join(names, ","))
+	return nil
+}
+
+func (e *recordingEncoder) WriteRow(record map[string]interface{}) error {
+	_, ok := record["age"]
+	e.log = append(e.log, fmt.Sprintf("row %v %t", record["name"], ok))
+	return nil
+}
+
+func (e *recordingEncoder) Close() error {
+	e.log = append(e.log, "close")
+	return nil
+}
+
+func TestCustomEncoder(t *testing.T) {
+	t.Parallel()
+
+	raw := `[{"name": "Jane", "age": 31}, {"name": "John"}]`
+	expected := "header name:0,age:1\nrow Jane true\nrow John false\nclose"
+
+	for name, convert := range conversions {
+		buffer := bytes.Buffer{}
+		enc := &recordingEncoder{}
+		opts := Options{Format: XlsxFormat, Encoder: enc}
+		if err := convert(strings.NewReader(raw), &buffer, opts); err != nil {
+			t.Fatalf("%s conversion failure: %s", name, err.Error())
+		}
+		if found := strings.Join(enc.log, "\n"); found != expected || buffer.Len() > 0 {
+			t.Errorf("%s conversion did not use the given encoder", name)
+			t.Logf("Expected:\n%s", expected)
+			t.Logf("Found:\n%s", found)
+		}
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		path     string
+		expected []string
+	}{
+		{"empty", "", nil},
+		{"pointer", "/data/items", []string{"data", "items"}},
+		{"pointer escapes", "/a~1b/c~0d", []string{"a/b", "c~d"}},
+		{"dotted", "data.items", []string{"data", "items"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			segments := parsePath(tc.path)
+			if strings.Join(segments, "|") != strings.Join(tc.expected, "|") || len(segments) != len(tc.expected) {
+				t.Errorf("expected %v, found %v", tc.expected, segments)
+			}
+		})
+	}
+}
+
 func readFile(path string) (string, error) {
 	file, err := os.Open(path)
 	if err != nil {
@@ -0,0 +1,56 @@
+package fjson2csv
+
+import (
+	"html"
+	"io"
+)
+
+// Writes records as an HTML table.
+type htmlEncoder struct {
+	ew      *errWriter
+	columns []Column
+	limit   int
+	rows    int
+	values  valueFormatter
+}
+
+func newHtmlEncoder(w io.Writer, size int, limit int) *htmlEncoder {
+	return &htmlEncoder{
+		ew:    newErrorWriter(w, size),
+		limit: limit,
+	}
+}
+
+func (h *htmlEncoder) WriteHeader(columns []Column) error {
+	h.columns = columns
+	h.ew.write("<table>\n<thead>\n<tr>")
+	for _, col := range columns {
+		h.ew.write("<th>" + html.EscapeString(col.Name) + "</th>")
+	}
+	h.ew.write("</tr>\n</thead>\n<tbody>\n")
+	return h.ew.err
+}
+
+func (h *htmlEncoder) WriteRow(record map[string]interface{}) error {
+	if h.limit > 0 && h.rows >= h.limit {
+		return h.ew.err
+	}
+	h.rows++
+
+	h.ew.write("<tr>")
+	for _, col := range h.columns {
+		text, err := h.values.format(col, col.Convert(record[col.Name]))
+		if err != nil {
+			return err
+		}
+		h.ew.write("<td>" + html.EscapeString(text) + "</td>")
+	}
+	h.ew.write("</tr>\n")
+	return h.ew.err
+}
+
+func (h *htmlEncoder) Close() error {
+	h.ew.write("</tbody>\n</table>\n")
+	h.ew.flush()
+	return h.ew.err
+}
@@ -0,0 +1,33 @@
+package fjson2csv
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestHtmlConvert(t *testing.T) {
+	t.Parallel()
+
+	raw := `[
+		{"id":1, "name":"<b>&</b>"},
+		{"id":2},
+		{"id":3}
+	]`
+	expected := "<table>\n<thead>\n<tr><th>id</th><th>name</th></tr>\n</thead>\n<tbody>\n" +
+		"<tr><td>1</td><td>&lt;b&gt;&amp;&lt;/b&gt;</td></tr>\n" +
+		"<tr><td>2</td><td></td></tr>\n" +
+		"</tbody>\n</table>\n"
+
+	buffer := bytes.Buffer{}
+	opts := Options{Format: HtmlFormat, PreviewRows: 2}
+	if err := UnbufferedConvert(strings.NewReader(raw), &buffer, opts); err != nil {
+		t.Fatalf("conversion failure: %s", err.Error())
+	}
+	if buffer.String() != expected {
+		t.Logf("HTML output did not match")
+		t.Logf("Expected:\n%s", expected)
+		t.Logf("Found:\n%s", buffer.String())
+		t.FailNow()
+	}
+}
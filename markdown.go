@@ -0,0 +1,78 @@
+package fjson2csv
+
+import (
+	"io"
+	"strings"
+)
+
+// Writes records as a GitHub Flavored Markdown (pipe) table. Numeric columns
+// are right-aligned, boolean columns centered and all others left-aligned.
+type markdownEncoder struct {
+	ew      *errWriter
+	columns []Column
+	limit   int
+	rows    int
+	values  valueFormatter
+}
+
+func newMarkdownEncoder(w io.Writer, size int, limit int) *markdownEncoder {
+	return &markdownEncoder{
+		ew:    newErrorWriter(w, size),
+		limit: limit,
+	}
+}
+
+func (m *markdownEncoder) WriteHeader(columns []Column) error {
+	m.columns = columns
+	if len(columns) == 0 {
+		return nil
+	}
+
+	names := make([]string, len(columns))
+	alignments := make([]string, len(columns))
+	for i, col := range columns {
+		names[i] = markdownEscaper.Replace(col.Name)
+		switch col.Type {
+		case IntegerColumn, FloatColumn:
+			alignments[i] = "---:"
+		case BooleanColumn:
+			alignments[i] = ":---:"
+		default:
+			alignments[i] = ":---"
+		}
+	}
+	m.ew.write("| " + strings.Join(names, " | ") + " |\n")
+	m.ew.write("| " + strings.Join(alignments, " | ") + " |\n")
+	return m.ew.err
+}
+
+func (m *markdownEncoder) WriteRow(record map[string]interface{}) error {
+	if len(m.columns) == 0 || (m.limit > 0 && m.rows >= m.limit) {
+		return m.ew.err
+	}
+	m.rows++
+
+	values := make([]string, len(m.columns))
+	for i, col := range m.columns {
+		text, err := m.values.format(col, col.Convert(record[col.Name]))
+		if err != nil {
+			return err
+		}
+		values[i] = markdownEscaper.Replace(text)
+	}
+	m.ew.write("| " + strings.Join(values, " | ") + " |\n")
+	return m.ew.err
+}
+
+func (m *markdownEncoder) Close() error {
+	m.ew.flush()
+	return m.ew.err
+}
+
+// Keeps cell contents from breaking table structure.
+var markdownEscaper = strings.NewReplacer(
+	`|`, `\|`,
+	"\r\n", "<br>",
+	"\n", "<br>",
+	"\r", "<br>",
+)
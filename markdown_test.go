@@ -0,0 +1,63 @@
+package fjson2csv
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMarkdownConvert(t *testing.T) {
+	t.Parallel()
+
+	raw := `[
+		{"id":1, "name":"a|b", "ok":true, "ratio":0.5},
+		{"id":2, "name":"line\nbreak", "ratio":1},
+		{"id":3, "ok":false, "ratio":2.25}
+	]`
+
+	cases := []struct {
+		name     string
+		opts     Options
+		expected string
+	}{
+		{
+			"all rows",
+			Options{Format: MarkdownFormat},
+			"| id | ratio | name | ok |\n" +
+				"| ---: | ---: | :--- | :---: |\n" +
+				"| 1 | 0.5 | a\\|b | true |\n" +
+				"| 2 | 1 | line<br>break |  |\n" +
+				"| 3 | 2.25 |  | false |\n",
+		},
+		{
+			"preview",
+			Options{Format: MarkdownFormat, PreviewRows: 1},
+			"| id | ratio | name | ok |\n" +
+				"| ---: | ---: | :--- | :---: |\n" +
+				"| 1 | 0.5 | a\\|b | true |\n",
+		},
+		{
+			"boolean spellings",
+			Options{Format: MarkdownFormat, TrueValue: "Y", FalseValue: "N"},
+			"| id | ratio | name | ok |\n" +
+				"| ---: | ---: | :--- | :---: |\n" +
+				"| 1 | 0.5 | a\\|b | Y |\n" +
+				"| 2 | 1 | line<br>break |  |\n" +
+				"| 3 | 2.25 |  | N |\n",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			buffer := bytes.Buffer{}
+			if err := BufferedConvert(strings.NewReader(raw), &buffer, tc.opts); err != nil {
+				t.Fatalf("conversion failure: %s", err.Error())
+			}
+			if buffer.String() != tc.expected {
+				t.Logf("Markdown output did not match")
+				t.Logf("Expected:\n%s", tc.expected)
+				t.Logf("Found:\n%s", buffer.String())
+				t.FailNow()
+			}
+		})
+	}
+}
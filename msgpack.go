@@ -0,0 +1,272 @@
+package fjson2csv
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// Input format of MessagePack records.
+const MsgpackFormat string = "msgpack"
+
+// Maximum depth of nested arrays and maps within binary input.
+const binary_max_depth int = 1000
+
+// Creates a source reading MessagePack records, given either as a single
+// array of maps or as a stream of maps.
+//
+// Values are converted to their JSON equivalents: numbers become float64,
+// binary values are encoded as text (see `Options.BinaryEncoding`) and
+// timestamps are formatted as RFC 3339.
+func NewMsgpackSource(r io.ReadSeeker, opts Options) RecordSource {
+	rsize, _ := getBufferSizes(opts)
+	return &binarySource{
+		r:    r,
+		size: rsize,
+		newDecoder: func(br *bufio.Reader) binaryDecoder {
+			return &msgpackDecoder{r: br, binary: opts.BinaryEncoding}
+		},
+	}
+}
+
+type msgpackDecoder struct {
+	r      *bufio.Reader
+	binary string
+}
+
+func (d *msgpackDecoder) readArray() (int, bool, error) {
+	peek, err := d.r.Peek(1)
+	if err != nil {
+		return 0, false, d.malformed(err)
+	}
+	b := peek[0]
+	if b&0xf0 != 0x90 && b != 0xdc && b != 0xdd {
+		return 0, false, nil
+	}
+	d.r.ReadByte()
+	if b&0xf0 == 0x90 {
+		return int(b & 0x0f), true, nil
+	}
+	n, err := d.readUint(2 << (b - 0xdc))
+	return int(n), true, err
+}
+
+func (d *msgpackDecoder) readBreak() (bool, error) {
+	return false, nil
+}
+
+func (d *msgpackDecoder) readRecord() (Record, error) {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		return nil, d.malformed(err)
+	}
+	n, ok, err := d.mapLength(b)
+	if err != nil {
+		return nil, err
+	} else if ok == false {
+		return nil, fmt.Errorf("malformed MessagePack: records must be maps")
+	}
+
+	record := Record{}
+	for i := uint64(0); i < n; i++ {
+		key, err := d.readValue(1)
+		if err != nil {
+			return nil, err
+		}
+		value, err := d.readValue(1)
+		if err != nil {
+			return nil, err
+		}
+		record = append(record, Field{mapKey(key), value})
+	}
+	return record, nil
+}
+
+// Reads the next value, at the given depth of nesting.
+func (d *msgpackDecoder) readValue(depth int) (interface{}, error) {
+	if depth > binary_max_depth {
+		return nil, fmt.Errorf("malformed MessagePack: values nested too deeply")
+	}
+	b, err := d.r.ReadByte()
+	if err != nil {
+		return nil, d.malformed(err)
+	}
+
+	// Maps
+	if n, ok, err := d.mapLength(b); err != nil {
+		return nil, err
+	} else if ok == true {
+		m := make(map[string]interface{})
+		for i := uint64(0); i < n; i++ {
+			key, err := d.readValue(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if m[mapKey(key)], err = d.readValue(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	}
+
+	// Arrays
+	var length uint64
+	switch {
+	case b&0xf0 == 0x90:
+		length = uint64(b & 0x0f)
+	case b == 0xdc, b == 0xdd:
+		if length, err = d.readUint(2 << (b - 0xdc)); err != nil {
+			return nil, err
+		}
+	default:
+		return d.readScalar(b)
+	}
+	values := []interface{}{}
+	for i := uint64(0); i < length; i++ {
+		value, err := d.readValue(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// Reads the length of a map, given the first byte of a value. Reports false
+// when the value is not a map.
+func (d *msgpackDecoder) mapLength(b byte) (uint64, bool, error) {
+	switch {
+	case b&0xf0 == 0x80:
+		return uint64(b & 0x0f), true, nil
+	case b == 0xde, b == 0xdf:
+		n, err := d.readUint(2 << (b - 0xde))
+		return n, true, err
+	}
+	return 0, false, nil
+}
+
+// Reads a value other than an array or map, given its first byte.
+func (d *msgpackDecoder) readScalar(b byte) (interface{}, error) {
+	switch {
+	case b <= 0x7f:
+		return float64(b), nil
+	case b >= 0xe0:
+		return float64(int8(b)), nil
+	case b&0xe0 == 0xa0:
+		return d.readString(uint64(b & 0x1f))
+	}
+
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		// Binary
+		n, err := d.readUint(1 << (b - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		data, err := readBytes(d.r, n)
+		if err != nil {
+			return nil, d.malformed(err)
+		}
+		return encodeBinary(data, d.binary), nil
+	case 0xc7, 0xc8, 0xc9:
+		// Extensions
+		n, err := d.readUint(1 << (b - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+		return d.readExtension(n)
+	case 0xca:
+		bits, err := d.readUint(4)
+		return float64(math.Float32frombits(uint32(bits))), err
+	case 0xcb:
+		bits, err := d.readUint(8)
+		return math.Float64frombits(bits), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n, err := d.readUint(1 << (b - 0xcc))
+		return float64(n), err
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (b - 0xd0)
+		n, err := d.readUint(size)
+		// Sign extend
+		shift := uint(64 - 8*size)
+		return float64(int64(n<<shift) >> shift), err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		// Fixed length extensions
+		return d.readExtension(1 << (b - 0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.readUint(1 << (b - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.readString(n)
+	}
+	return nil, fmt.Errorf("malformed MessagePack: invalid type 0x%02x", b)
+}
+
+func (d *msgpackDecoder) readString(n uint64) (interface{}, error) {
+	data, err := readBytes(d.r, n)
+	if err != nil {
+		return nil, d.malformed(err)
+	}
+	return string(data), nil
+}
+
+// Reads the type and data of an extension. Timestamps are formatted as
+// RFC 3339, and any other extension is treated as binary.
+func (d *msgpackDecoder) readExtension(n uint64) (interface{}, error) {
+	kind, err := d.r.ReadByte()
+	if err != nil {
+		return nil, d.malformed(err)
+	}
+	data, err := readBytes(d.r, n)
+	if err != nil {
+		return nil, d.malformed(err)
+	}
+	if int8(kind) != -1 {
+		return encodeBinary(data, d.binary), nil
+	}
+
+	var seconds, nanoseconds int64
+	switch n {
+	case 4:
+		seconds = int64(binary.BigEndian.Uint32(data))
+	case 8:
+		bits := binary.BigEndian.Uint64(data)
+		seconds, nanoseconds = int64(bits&0x3ffffffff), int64(bits>>34)
+	case 12:
+		nanoseconds = int64(binary.BigEndian.Uint32(data))
+		seconds = int64(binary.BigEndian.Uint64(data[4:]))
+	default:
+		return nil, fmt.Errorf("malformed MessagePack: invalid timestamp")
+	}
+	return time.Unix(seconds, nanoseconds).UTC().Format(time.RFC3339Nano), nil
+}
+
+// Reads a big-endian unsigned integer of the given number of bytes.
+func (d *msgpackDecoder) readUint(size int) (uint64, error) {
+	data, err := readBytes(d.r, uint64(size))
+	if err != nil {
+		return 0, d.malformed(err)
+	}
+	var n uint64
+	for _, b := range data {
+		n = n<<8 | uint64(b)
+	}
+	return n, nil
+}
+
+func (d *msgpackDecoder) malformed(err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("malformed MessagePack: %s", err.Error())
+}
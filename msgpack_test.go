@@ -0,0 +1,74 @@
+package fjson2csv
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// Decodes hexadecimal test input, ignoring spaces.
+func decodeHex(t *testing.T, raw string) []byte {
+	data, err := hex.DecodeString(strings.Replace(raw, " ", "", -1))
+	if err != nil {
+		t.Fatalf("invalid test input: %s", err.Error())
+	}
+	return data
+}
+
+func TestMsgpackSource(t *testing.T) {
+	t.Parallel()
+
+	records := []string{
+		// {"a":1, "b":"x", "c":bin(01 02 ff)}
+		"83 a1 61 01 a1 62 a1 78 a1 63 c4 03 01 02 ff",
+		// {"d":-2, "e":nil, "t":timestamp(0)}
+		"83 a1 64 fe a1 65 c0 a1 74 d6 ff 00 00 00 00",
+		// {"f":1.5, "g":-1000, "h":[1, {"k":true}]}
+		"83 a1 66 cb 3f f8 00 00 00 00 00 00 a1 67 d1 fc 18 a1 68 92 01 81 a1 6b c3",
+	}
+	expected := []string{
+		"a=1 b=x c=AQL/",
+		"d=-2 e=<nil> t=1970-01-01T00:00:00Z",
+		"f=1.5 g=-1000 h=[1 map[k:true]]",
+	}
+
+	cases := []struct {
+		name     string
+		raw      string
+		opts     Options
+		expected []string
+		willFail bool
+	}{
+		{"stream", strings.Join(records, ""), Options{}, expected, false},
+		{"array", "93" + strings.Join(records, ""), Options{}, expected, false},
+		{"array16", "dc 00 01" + records[0], Options{}, expected[:1], false},
+		{"hex binary", records[0], Options{BinaryEncoding: HexEncoding}, []string{"a=1 b=x c=0102ff"}, false},
+		{"integer keys", "81 07 a1 78", Options{}, []string{"7=x"}, false},
+		{"empty", "", Options{}, []string{}, false},
+		{"empty array", "90", Options{}, []string{}, false},
+		{"non-map record", records[0] + "01", Options{}, expected[:1], true},
+		{"truncated record", records[0] + "81 a1 61", Options{}, expected[:1], true},
+		{"invalid type", "81 a1 61 c1", Options{}, []string{}, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			source := NewMsgpackSource(bytes.NewReader(decodeHex(t, tc.raw)), tc.opts)
+			for pass := 1; pass <= 2; pass++ {
+				found, err := readSource(source)
+				if (err != nil) != tc.willFail {
+					t.Fatalf("expected failure: %t, found: %v", tc.willFail, err)
+				}
+				if strings.Join(found, "\n") != strings.Join(tc.expected, "\n") {
+					t.Logf("pass %d did not match expected records", pass)
+					t.Logf("Expected:\n%s", strings.Join(tc.expected, "\n"))
+					t.Logf("Found:\n%s", strings.Join(found, "\n"))
+					t.FailNow()
+				}
+				if err := source.Reset(); err != nil {
+					t.Fatalf("failed to reset source: %s", err.Error())
+				}
+			}
+		})
+	}
+}
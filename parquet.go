@@ -0,0 +1,365 @@
+package fjson2csv
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+const default_row_group_size int = 100000
+
+// Parquet physical types, repetition types and encodings.
+const (
+	parquet_boolean    int32 = 0
+	parquet_int64      int32 = 2
+	parquet_double     int32 = 5
+	parquet_byte_array int32 = 6
+
+	parquet_required int32 = 0
+	parquet_optional int32 = 1
+
+	parquet_utf8         int32 = 0
+	parquet_plain        int32 = 0
+	parquet_rle          int32 = 3
+	parquet_data_page    int32 = 0
+	parquet_uncompressed int32 = 0
+)
+
+const parquet_magic string = "PAR1"
+
+// Writes records as an Apache Parquet file, using the column types inferred
+// while indexing.
+//
+// Values are buffered by column until a row group is full, then each column
+// is written out as a single uncompressed, PLAIN encoded data page. The file
+// footer describing the schema and all row groups is written on close.
+type parquetEncoder struct {
+	w         *bufio.Writer
+	offset    int64
+	columns   []Column
+	chunks    []parquetChunk
+	groupSize int
+	rows      int64
+	total     int64
+	groups    []parquetRowGroup
+	err       error
+}
+
+// Buffered values of a column within the current row group.
+type parquetChunk struct {
+	levels []bool
+	bools  []bool
+	values bytes.Buffer
+}
+
+// Metadata of a row group which has been written out.
+type parquetRowGroup struct {
+	columns []parquetColumnChunk
+	size    int64
+	rows    int64
+}
+
+type parquetColumnChunk struct {
+	offset int64
+	size   int64
+	values int64
+}
+
+func newParquetEncoder(w io.Writer, size int, groupSize int) *parquetEncoder {
+	if groupSize < 1 {
+		groupSize = default_row_group_size
+	}
+	return &parquetEncoder{
+		w:         bufio.NewWriterSize(w, size),
+		groupSize: groupSize,
+	}
+}
+
+func (p *parquetEncoder) WriteHeader(columns []Column) error {
+	p.columns = columns
+	p.chunks = make([]parquetChunk, len(columns))
+	p.write([]byte(parquet_magic))
+	return p.err
+}
+
+func (p *parquetEncoder) WriteRow(record map[string]interface{}) error {
+	if p.err != nil {
+		return p.err
+	}
+	for i, col := range p.columns {
+		chunk := &p.chunks[i]
+
+		// Values which can't be converted to the column's type are null too
+		value := col.Convert(record[col.Name])
+		if value == nil {
+			if col.Nullable == false {
+				p.err = fmt.Errorf("null value in required column '%s'", col.Name)
+				return p.err
+			}
+			chunk.levels = append(chunk.levels, false)
+			continue
+		}
+		chunk.levels = append(chunk.levels, true)
+
+		switch v := value.(type) {
+		case int64:
+			chunk.values.Write(binary.LittleEndian.AppendUint64(nil, uint64(v)))
+		case float64:
+			chunk.values.Write(binary.LittleEndian.AppendUint64(nil, math.Float64bits(v)))
+		case bool:
+			chunk.bools = append(chunk.bools, v)
+		case string:
+			chunk.values.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(v))))
+			chunk.values.WriteString(v)
+		}
+	}
+	p.rows++
+	if p.rows >= int64(p.groupSize) {
+		p.flushRowGroup()
+	}
+	return p.err
+}
+
+func (p *parquetEncoder) Close() error {
+	if p.rows > 0 {
+		p.flushRowGroup()
+	}
+	if p.err != nil {
+		return p.err
+	}
+
+	footer := p.footer()
+	p.write(footer)
+	p.write(binary.LittleEndian.AppendUint32(nil, uint32(len(footer))))
+	p.write([]byte(parquet_magic))
+	if p.err == nil {
+		p.err = p.w.Flush()
+	}
+	return p.err
+}
+
+// Writes out buffered column values as a row group.
+func (p *parquetEncoder) flushRowGroup() {
+	group := parquetRowGroup{rows: p.rows}
+	for i, col := range p.columns {
+		chunk := &p.chunks[i]
+
+		// Definition levels (only for nullable columns), then values
+		page := bytes.Buffer{}
+		if col.Nullable {
+			levels := encodeBitPacked(chunk.levels)
+			page.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(levels))))
+			page.Write(levels)
+		}
+		if col.Type == BooleanColumn {
+			page.Write(packBits(chunk.bools))
+		} else {
+			page.Write(chunk.values.Bytes())
+		}
+
+		t := thriftWriter{}
+		t.structBegin()
+		t.i32(1, parquet_data_page)
+		t.i32(2, int32(page.Len()))
+		t.i32(3, int32(page.Len()))
+		t.structField(5)
+		t.i32(1, int32(len(chunk.levels)))
+		t.i32(2, parquet_plain)
+		t.i32(3, parquet_rle)
+		t.i32(4, parquet_rle)
+		t.structEnd()
+		t.structEnd()
+
+		meta := parquetColumnChunk{
+			offset: p.offset,
+			size:   int64(t.buffer.Len() + page.Len()),
+			values: int64(len(chunk.levels)),
+		}
+		p.write(t.buffer.Bytes())
+		p.write(page.Bytes())
+		group.columns = append(group.columns, meta)
+		group.size += meta.size
+
+		p.chunks[i] = parquetChunk{}
+	}
+	p.groups = append(p.groups, group)
+	p.total += p.rows
+	p.rows = 0
+}
+
+// Encodes the file metadata.
+func (p *parquetEncoder) footer() []byte {
+	t := thriftWriter{}
+	t.structBegin()
+	t.i32(1, 1)
+
+	// Schema, as a flat list whose root element holds all columns
+	t.listField(2, thrift_struct, len(p.columns)+1)
+	t.structBegin()
+	t.binary(4, "schema")
+	t.i32(5, int32(len(p.columns)))
+	t.structEnd()
+	for _, col := range p.columns {
+		t.structBegin()
+		t.i32(1, parquetType(col.Type))
+		if col.Nullable {
+			t.i32(3, parquet_optional)
+		} else {
+			t.i32(3, parquet_required)
+		}
+		t.binary(4, col.Name)
+		if col.Type == StringColumn {
+			t.i32(6, parquet_utf8)
+		}
+		t.structEnd()
+	}
+
+	t.i64(3, p.total)
+	t.listField(4, thrift_struct, len(p.groups))
+	for _, group := range p.groups {
+		t.structBegin()
+		t.listField(1, thrift_struct, len(group.columns))
+		for i, chunk := range group.columns {
+			t.structBegin()
+			t.i64(2, chunk.offset)
+			t.structField(3)
+			t.i32(1, parquetType(p.columns[i].Type))
+			t.listField(2, thrift_i32, 2)
+			t.varint(int64(parquet_plain))
+			t.varint(int64(parquet_rle))
+			t.listField(3, thrift_binary, 1)
+			t.string(p.columns[i].Name)
+			t.i32(4, parquet_uncompressed)
+			t.i64(5, chunk.values)
+			t.i64(6, chunk.size)
+			t.i64(7, chunk.size)
+			t.i64(9, chunk.offset)
+			t.structEnd()
+			t.structEnd()
+		}
+		t.i64(2, group.size)
+		t.i64(3, group.rows)
+		t.structEnd()
+	}
+	t.binary(6, "fjson2csv")
+	t.structEnd()
+	return t.buffer.Bytes()
+}
+
+func (p *parquetEncoder) write(data []byte) {
+	if p.err == nil {
+		var n int
+		n, p.err = p.w.Write(data)
+		p.offset += int64(n)
+	}
+}
+
+func parquetType(kind ColumnType) int32 {
+	switch kind {
+	case IntegerColumn:
+		return parquet_int64
+	case FloatColumn:
+		return parquet_double
+	case BooleanColumn:
+		return parquet_boolean
+	default:
+		return parquet_byte_array
+	}
+}
+
+// Packs booleans into bits, least significant bit first.
+func packBits(values []bool) []byte {
+	packed := make([]byte, (len(values)+7)/8)
+	for i, v := range values {
+		if v {
+			packed[i/8] |= 1 << uint(i%8)
+		}
+	}
+	return packed
+}
+
+// Encodes 1-bit values as a single bit-packed run of the RLE/bit-packing
+// hybrid encoding.
+func encodeBitPacked(values []bool) []byte {
+	groups := (len(values) + 7) / 8
+	encoded := binary.AppendUvarint(nil, uint64(groups<<1|1))
+	return append(encoded, packBits(values)...)
+}
+
+// Thrift compact protocol field types.
+const (
+	thrift_i32    byte = 5
+	thrift_i64    byte = 6
+	thrift_binary byte = 8
+	thrift_list   byte = 9
+	thrift_struct byte = 12
+)
+
+// Minimal encoder for the Thrift compact protocol, which Parquet uses for
+// page headers and file metadata.
+type thriftWriter struct {
+	buffer bytes.Buffer
+	fields []int16
+}
+
+func (t *thriftWriter) structBegin() {
+	t.fields = append(t.fields, 0)
+}
+
+func (t *thriftWriter) structEnd() {
+	t.buffer.WriteByte(0)
+	t.fields = t.fields[:len(t.fields)-1]
+}
+
+func (t *thriftWriter) field(id int16, kind byte) {
+	last := &t.fields[len(t.fields)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.buffer.WriteByte(byte(delta)<<4 | kind)
+	} else {
+		t.buffer.WriteByte(kind)
+		t.varint(int64(id))
+	}
+	*last = id
+}
+
+func (t *thriftWriter) varint(v int64) {
+	t.buffer.Write(binary.AppendUvarint(nil, uint64(v<<1)^uint64(v>>63)))
+}
+
+func (t *thriftWriter) string(s string) {
+	t.buffer.Write(binary.AppendUvarint(nil, uint64(len(s))))
+	t.buffer.WriteString(s)
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thrift_i32)
+	t.varint(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thrift_i64)
+	t.varint(v)
+}
+
+func (t *thriftWriter) binary(id int16, s string) {
+	t.field(id, thrift_binary)
+	t.string(s)
+}
+
+func (t *thriftWriter) structField(id int16) {
+	t.field(id, thrift_struct)
+	t.structBegin()
+}
+
+func (t *thriftWriter) listField(id int16, kind byte, size int) {
+	t.field(id, thrift_list)
+	if size < 15 {
+		t.buffer.WriteByte(byte(size)<<4 | kind)
+	} else {
+		t.buffer.WriteByte(0xf0 | kind)
+		t.buffer.Write(binary.AppendUvarint(nil, uint64(size)))
+	}
+}
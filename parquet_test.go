@@ -0,0 +1,452 @@
+package fjson2csv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParquetConvert(t *testing.T) {
+	t.Parallel()
+
+	raw := `[
+		{"id":1, "name":"a", "ok":true, "ratio":0.5},
+		{"id":2, "ratio":1},
+		{"id":3, "name":"c", "ok":false, "ratio":2.25}
+	]`
+
+	for name, data := range convertBoth(t, raw, Options{Format: ParquetFormat, RowGroupSize: 2}) {
+		if string(data[:4]) != parquet_magic || string(data[len(data)-4:]) != parquet_magic {
+			t.Fatalf("%s: missing Parquet magic number", name)
+		}
+		size := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+		footer := data[len(data)-8-size : len(data)-8]
+
+		// Schema elements for id (INT64, required) and name (UTF8, optional)
+		for _, fragment := range []string{"\x15\x04\x25\x00\x18\x02id", "\x15\x0c\x25\x02\x18\x04name\x25\x00"} {
+			if bytes.Contains(footer, []byte(fragment)) == false {
+				t.Errorf("%s: footer is missing schema element %q", name, fragment)
+			}
+		}
+		// Two row groups: of 2 and 1 records
+		if bytes.Contains(footer, []byte("\x16\x04\x00")) == false || bytes.Contains(footer, []byte("\x16\x02\x00")) == false {
+			t.Errorf("%s: footer does not describe the expected row groups", name)
+		}
+	}
+}
+
+func TestThriftWriter(t *testing.T) {
+	t.Parallel()
+
+	w := thriftWriter{}
+	w.structBegin()
+	w.i32(1, 3)
+	w.binary(4, "ab")
+	w.listField(5, thrift_i32, 2)
+	w.varint(0)
+	w.varint(-1)
+	w.i64(21, 1)
+	w.structEnd()
+
+	expected := []byte{0x15, 0x06, 0x38, 0x02, 'a', 'b', 0x19, 0x25, 0x00, 0x01, 0x06, 0x2a, 0x02, 0x00}
+	if bytes.Equal(w.buffer.Bytes(), expected) == false {
+		t.Errorf("expected % x, found % x", expected, w.buffer.Bytes())
+	}
+}
+
+func TestEncodeBitPacked(t *testing.T) {
+	t.Parallel()
+
+	values := []bool{true, false, true, true, false, false, false, false, true}
+	expected := []byte{0x05, 0x0d, 0x01}
+	if encoded := encodeBitPacked(values); bytes.Equal(encoded, expected) == false {
+		t.Errorf("expected % x, found % x", expected, encoded)
+	}
+}
+
+func TestParquetReadBack(t *testing.T) {
+	t.Parallel()
+
+	raw := `[
+		{"id":1, "name":"a", "ok":true, "ratio":0.5},
+		{"id":2, "ratio":1},
+		{"id":3, "name":"c", "ok":false, "ratio":2.25},
+		{"id":4, "name":"", "ok":true, "ratio":-3}
+	]`
+
+	for name, data := range convertBoth(t, raw, Options{Format: ParquetFormat, RowGroupSize: 3}) {
+		t.Run(name, func(t *testing.T) {
+			columns, rows := readParquet(t, data)
+
+			expectedColumns := "id:2:required,ratio:5:required,name:6:optional,ok:0:optional"
+			if found := describeParquetColumns(columns); found != expectedColumns {
+				t.Errorf("expected columns %s, found %s", expectedColumns, found)
+			}
+			expected := "1 0.5 a true|2 1 <nil> <nil>|3 2.25 c false|4 -3  true"
+			if found := describeParquetRows(columns, rows); found != expected {
+				t.Errorf("expected rows %s, found %s", expected, found)
+			}
+		})
+	}
+}
+
+func TestParquetConflicts(t *testing.T) {
+	t.Parallel()
+
+	raw := `[
+		{"id":1, "zip":"02134"},
+		{"id":2, "zip":2134},
+		{"id":3, "zip":"abc"},
+		{"id":4, "zip":true}
+	]`
+
+	cases := []struct {
+		name     string
+		opts     Options
+		columns  string
+		expected string
+	}{
+		{
+			"string policy",
+			Options{Format: ParquetFormat},
+			"id:2:required,zip:6:required",
+			"1 02134|2 2134|3 abc|4 true",
+		},
+		{
+			"number policy",
+			Options{Format: ParquetFormat, ConflictPolicy: NumberPolicy},
+			"id:2:required,zip:2:optional",
+			"1 2134|2 2134|3 <nil>|4 <nil>",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			buffer := bytes.Buffer{}
+			if err := BufferedConvert(strings.NewReader(raw), &buffer, tc.opts); err != nil {
+				t.Fatalf("conversion failure: %s", err.Error())
+			}
+			columns, rows := readParquet(t, buffer.Bytes())
+			if found := describeParquetColumns(columns); found != tc.columns {
+				t.Errorf("expected columns %s, found %s", tc.columns, found)
+			}
+			if found := describeParquetRows(columns, rows); found != tc.expected {
+				t.Errorf("expected rows %s, found %s", tc.expected, found)
+			}
+		})
+	}
+}
+
+/*
+ * A minimal Parquet reader, written from the format specification rather
+ * than from the encoder, which decodes the file metadata, the page headers
+ * and the definition levels and PLAIN encoded values of every page. Any
+ * mismatch between the metadata, levels and values fails the test.
+ */
+
+type parquetReadColumn struct {
+	name     string
+	kind     int64
+	optional bool
+}
+
+func readParquet(t *testing.T, data []byte) ([]parquetReadColumn, []map[string]interface{}) {
+	t.Helper()
+	if len(data) < 12 || string(data[:4]) != parquet_magic || string(data[len(data)-4:]) != parquet_magic {
+		t.Fatalf("missing Parquet magic number")
+	}
+	size := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	if size > len(data)-12 {
+		t.Fatalf("invalid footer length %d", size)
+	}
+	footer := &thriftReader{data: data[len(data)-8-size : len(data)-8]}
+	meta := footer.readStruct()
+	if footer.err != nil || footer.pos != len(footer.data) {
+		t.Fatalf("malformed footer: %v (read %d of %d bytes)", footer.err, footer.pos, len(footer.data))
+	}
+
+	// Schema: a root element, then one element per column
+	schema := meta[2].([]interface{})
+	columns := []parquetReadColumn{}
+	for _, element := range schema[1:] {
+		e := element.(map[int16]interface{})
+		columns = append(columns, parquetReadColumn{
+			name:     e[4].(string),
+			kind:     e[1].(int64),
+			optional: e[3].(int64) == int64(parquet_optional),
+		})
+	}
+
+	rows := []map[string]interface{}{}
+	for _, g := range meta[4].([]interface{}) {
+		group := g.(map[int16]interface{})
+		count := int(group[3].(int64))
+		chunks := group[1].([]interface{})
+		if len(chunks) != len(columns) {
+			t.Fatalf("row group has %d column chunks, expected %d", len(chunks), len(columns))
+		}
+		groupRows := make([]map[string]interface{}, count)
+		for i := range groupRows {
+			groupRows[i] = map[string]interface{}{}
+		}
+		for i, c := range chunks {
+			col := columns[i]
+			chunkMeta := c.(map[int16]interface{})[3].(map[int16]interface{})
+			if chunkMeta[5].(int64) != int64(count) {
+				t.Fatalf("column '%s' has %d values in a row group of %d rows", col.name, chunkMeta[5].(int64), count)
+			}
+
+			offset := int(chunkMeta[9].(int64))
+			header := &thriftReader{data: data[offset:]}
+			page := header.readStruct()
+			if header.err != nil {
+				t.Fatalf("malformed page header of column '%s': %s", col.name, header.err.Error())
+			}
+			pageSize := int(page[3].(int64))
+			if int64(header.pos+pageSize) != chunkMeta[7].(int64) {
+				t.Fatalf("column '%s': chunk size %d does not match its page", col.name, chunkMeta[7].(int64))
+			}
+			if values := page[5].(map[int16]interface{})[1].(int64); values != int64(count) {
+				t.Fatalf("column '%s': page has %d values, expected %d", col.name, values, count)
+			}
+			body := data[offset+header.pos : offset+header.pos+pageSize]
+
+			// Definition levels
+			defined := make([]bool, count)
+			if col.optional {
+				length := int(binary.LittleEndian.Uint32(body))
+				defined = decodeLevels(t, body[4:4+length], count)
+				body = body[4+length:]
+			} else {
+				for j := range defined {
+					defined[j] = true
+				}
+			}
+
+			// Values, for defined levels only
+			n := 0
+			for _, d := range defined {
+				if d {
+					n++
+				}
+			}
+			values := []interface{}{}
+			switch int32(col.kind) {
+			case parquet_boolean:
+				if len(body) != (n+7)/8 {
+					t.Fatalf("column '%s': %d bytes for %d booleans", col.name, len(body), n)
+				}
+				for j := 0; j < n; j++ {
+					values = append(values, body[j/8]&(1<<uint(j%8)) != 0)
+				}
+				body = nil
+			case parquet_int64, parquet_double:
+				for j := 0; j < n && len(body) >= 8; j++ {
+					bits := binary.LittleEndian.Uint64(body)
+					if int32(col.kind) == parquet_int64 {
+						values = append(values, int64(bits))
+					} else {
+						values = append(values, math.Float64frombits(bits))
+					}
+					body = body[8:]
+				}
+			case parquet_byte_array:
+				for j := 0; j < n && len(body) >= 4; j++ {
+					length := int(binary.LittleEndian.Uint32(body))
+					if len(body) < 4+length {
+						break
+					}
+					values = append(values, string(body[4:4+length]))
+					body = body[4+length:]
+				}
+			}
+			if len(values) != n || len(body) != 0 {
+				t.Fatalf("column '%s': %d levels defined, but %d values and %d bytes left", col.name, n, len(values), len(body))
+			}
+
+			for j, d := range defined {
+				if d {
+					groupRows[j][col.name] = values[0]
+					values = values[1:]
+				} else {
+					groupRows[j][col.name] = nil
+				}
+			}
+		}
+		rows = append(rows, groupRows...)
+	}
+	if int64(len(rows)) != meta[3].(int64) {
+		t.Fatalf("file has %d rows, but its metadata gives %d", len(rows), meta[3].(int64))
+	}
+	return columns, rows
+}
+
+// Decodes 1-bit definition levels in the RLE/bit-packing hybrid encoding.
+func decodeLevels(t *testing.T, data []byte, count int) []bool {
+	t.Helper()
+	levels := []bool{}
+	for len(data) > 0 {
+		header, n := binary.Uvarint(data)
+		data = data[n:]
+		if header&1 == 1 {
+			groups := int(header >> 1)
+			if len(data) < groups {
+				t.Fatalf("truncated bit-packed levels")
+			}
+			for i := 0; i < groups*8; i++ {
+				levels = append(levels, data[i/8]&(1<<uint(i%8)) != 0)
+			}
+			data = data[groups:]
+		} else {
+			if len(data) < 1 {
+				t.Fatalf("truncated RLE levels")
+			}
+			for i := 0; i < int(header>>1); i++ {
+				levels = append(levels, data[0] == 1)
+			}
+			data = data[1:]
+		}
+	}
+	if len(levels) < count {
+		t.Fatalf("found %d levels, expected %d", len(levels), count)
+	}
+	return levels[:count]
+}
+
+// Reads structures in the Thrift compact protocol, as maps of field IDs to
+// values (int64, float64, bool, string, lists and nested maps).
+type thriftReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *thriftReader) byte() byte {
+	if r.pos >= len(r.data) {
+		if r.err == nil {
+			r.err = io.ErrUnexpectedEOF
+		}
+		return 0
+	}
+	r.pos++
+	return r.data[r.pos-1]
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	if r.pos >= len(r.data) {
+		if r.err == nil {
+			r.err = io.ErrUnexpectedEOF
+		}
+		return 0
+	}
+	v, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 {
+		if r.err == nil {
+			r.err = io.ErrUnexpectedEOF
+		}
+		return 0
+	}
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) zigzag() int64 {
+	v := r.uvarint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) readStruct() map[int16]interface{} {
+	fields := map[int16]interface{}{}
+	var last int16
+	for r.err == nil {
+		b := r.byte()
+		if b == 0 {
+			break
+		}
+		kind := b & 0x0f
+		if delta := int16(b >> 4); delta != 0 {
+			last += delta
+		} else {
+			last = int16(r.zigzag())
+		}
+		switch kind {
+		case 1, 2:
+			fields[last] = kind == 1
+		default:
+			fields[last] = r.readValue(kind)
+		}
+	}
+	return fields
+}
+
+func (r *thriftReader) readValue(kind byte) interface{} {
+	switch kind {
+	case 1:
+		return true
+	case 2:
+		return false
+	case 3:
+		return int64(int8(r.byte()))
+	case 4, 5, 6:
+		return r.zigzag()
+	case 7:
+		if r.pos+8 > len(r.data) {
+			r.err = io.ErrUnexpectedEOF
+			return nil
+		}
+		r.pos += 8
+		return math.Float64frombits(binary.LittleEndian.Uint64(r.data[r.pos-8:]))
+	case 8:
+		n := int(r.uvarint())
+		if r.pos+n > len(r.data) {
+			r.err = io.ErrUnexpectedEOF
+			return nil
+		}
+		r.pos += n
+		return string(r.data[r.pos-n : r.pos])
+	case 9:
+		header := r.byte()
+		size := int(header >> 4)
+		if size == 15 {
+			size = int(r.uvarint())
+		}
+		list := []interface{}{}
+		for i := 0; i < size && r.err == nil; i++ {
+			list = append(list, r.readValue(header&0x0f))
+		}
+		return list
+	case 12:
+		return r.readStruct()
+	}
+	r.err = fmt.Errorf("unsupported Thrift type %d", kind)
+	return nil
+}
+
+// Describes columns as "name:type:repetition".
+func describeParquetColumns(columns []parquetReadColumn) string {
+	described := []string{}
+	for _, col := range columns {
+		repetition := "required"
+		if col.optional {
+			repetition = "optional"
+		}
+		described = append(described, fmt.Sprintf("%s:%d:%s", col.name, col.kind, repetition))
+	}
+	return strings.Join(described, ",")
+}
+
+// Describes rows as their values in column order, separated by '|'.
+func describeParquetRows(columns []parquetReadColumn, rows []map[string]interface{}) string {
+	described := []string{}
+	for _, row := range rows {
+		values := []string{}
+		for _, col := range columns {
+			values = append(values, fmt.Sprint(row[col.name]))
+		}
+		described = append(described, strings.Join(values, " "))
+	}
+	return strings.Join(described, "|")
+}
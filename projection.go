@@ -0,0 +1,213 @@
+package fjson2csv
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+ * Projections choose the values written as columns, by their paths within
+ * each record, eg.:
+ *
+ *   id, user.name as name, tags[0] as first_tag, ."odd key"
+ *
+ * Paths are made of property names separated by dots (with an optional
+ * leading dot, as in jq), array indexes in brackets (negative indexes count
+ * from the end) and property names with other characters in quotes, either
+ * after a dot or in brackets (`["odd key"]`). Columns are named by their
+ * alias, or otherwise by their path as written (without any leading dot) or,
+ * for paths of a single property, by the property's name.
+ */
+
+// A column of a projection, and the path of its values.
+type projected struct {
+	name string
+	path []interface{}
+}
+
+// Selects the values of a projection's columns from each record.
+type projection []projected
+
+// Parses a projection. An empty projection is no projection at all.
+func parseProjection(spec string) (projection, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+	p := projection{}
+	names := map[string]bool{}
+	rest := spec
+	for {
+		col, remaining, err := parseProjected(rest, len(spec)-len(rest))
+		if err != nil {
+			return nil, err
+		}
+		if names[col.name] == true {
+			return nil, fmt.Errorf("malformed projection: duplicate column '%s'", col.name)
+		}
+		names[col.name] = true
+		p = append(p, col)
+
+		rest = strings.TrimLeft(remaining, " \t\r\n")
+		if rest == "" {
+			return p, nil
+		}
+		if rest[0] != ',' {
+			return nil, fmt.Errorf("malformed projection: expected ',' at position %d", len(spec)-len(rest)+1)
+		}
+		rest = rest[1:]
+	}
+}
+
+// Parses a path and its optional alias, returning the rest of the input.
+func parseProjected(input string, offset int) (projected, string, error) {
+	col := projected{}
+	text := strings.TrimLeft(input, " \t\r\n")
+	position := func() int {
+		return offset + len(input) - len(text) + 1
+	}
+
+	// Path
+	start := text
+	text = strings.TrimPrefix(text, ".")
+	for first := true; ; first = false {
+		switch {
+		case strings.HasPrefix(text, "["):
+			end := strings.Index(text, "]")
+			if end < 0 {
+				return col, "", fmt.Errorf("malformed projection: unterminated '[' at position %d", position())
+			}
+			inner := strings.TrimSpace(text[1:end])
+			if key, err := strconv.Unquote(inner); err == nil && strings.HasPrefix(inner, `"`) {
+				col.path = append(col.path, key)
+			} else if index, err := strconv.Atoi(inner); err == nil {
+				col.path = append(col.path, index)
+			} else {
+				return col, "", fmt.Errorf("malformed projection: invalid index '%s' at position %d", inner, position())
+			}
+			text = text[end+1:]
+		case first || strings.HasPrefix(text, "."):
+			if first == false {
+				text = text[1:]
+			}
+			key, remaining, ok := projectedKey(text)
+			if ok == false {
+				return col, "", fmt.Errorf("malformed projection: expected a property name at position %d", position())
+			}
+			col.path = append(col.path, key)
+			text = remaining
+		default:
+			col.name = strings.TrimPrefix(strings.TrimSpace(start[:len(start)-len(text)]), ".")
+			if key, ok := col.path[0].(string); ok == true && len(col.path) == 1 {
+				col.name = key
+			}
+			return parseAlias(col, text, position)
+		}
+	}
+}
+
+// Reads a property name, either quoted or up to the next separator.
+func projectedKey(text string) (string, string, bool) {
+	if strings.HasPrefix(text, `"`) {
+		for end := 1; end < len(text); end++ {
+			if text[end] == '\\' {
+				end++
+			} else if text[end] == '"' {
+				key, err := strconv.Unquote(text[:end+1])
+				return key, text[end+1:], err == nil
+			}
+		}
+		return "", text, false
+	}
+	end := strings.IndexAny(text, ".[], \t\r\n")
+	if end < 0 {
+		end = len(text)
+	}
+	return text[:end], text[end:], end > 0
+}
+
+// Reads the alias of a column (" as name"), if one is given.
+func parseAlias(col projected, text string, position func() int) (projected, string, error) {
+	trimmed := strings.TrimLeft(text, " \t\r\n")
+	if strings.HasPrefix(trimmed, "as ") == false && strings.HasPrefix(trimmed, "as\t") == false {
+		return col, text, nil
+	}
+	alias, remaining, ok := projectedKey(strings.TrimLeft(trimmed[3:], " \t\r\n"))
+	if ok == false {
+		return col, "", fmt.Errorf("malformed projection: expected an alias at position %d", position()+len(text)-len(trimmed)+3)
+	}
+	col.name = alias
+	return col, remaining, nil
+}
+
+// Returns the projected values of a record. Columns whose paths are not
+// found in the record are missing from it.
+func (p projection) apply(record map[string]interface{}) map[string]interface{} {
+	values := make(map[string]interface{}, len(p))
+	for _, col := range p {
+		var value interface{} = record
+		found := true
+		for _, segment := range col.path {
+			switch s := segment.(type) {
+			case string:
+				var object map[string]interface{}
+				if object, found = value.(map[string]interface{}); found == true {
+					value, found = object[s]
+				}
+			case int:
+				var array []interface{}
+				if array, found = value.([]interface{}); found == true {
+					if s < 0 {
+						s += len(array)
+					}
+					if found = s >= 0 && s < len(array); found == true {
+						value = array[s]
+					}
+				}
+			}
+			if found == false {
+				break
+			}
+		}
+		if found == true {
+			values[col.name] = projectedValue(value)
+		}
+	}
+	return values
+}
+
+// Returns a projected value as it is written. Objects and arrays are written
+// as their JSON text, since columns only hold scalar values.
+func projectedValue(value interface{}) interface{} {
+	switch value.(type) {
+	case map[string]interface{}, []interface{}:
+		buffer := bytes.Buffer{}
+		enc := json.NewEncoder(&buffer)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(value); err != nil {
+			return nil
+		}
+		return strings.TrimSuffix(buffer.String(), "\n")
+	}
+	return value
+}
+
+// Orders the indexed keys with the columns of the projection first, in the
+// order given, and any others (eg. derived columns) after them. Columns of
+// the projection are kept even when none of the records had their values.
+func (c *converter) orderProjection() {
+	sorted := make([]string, 0, len(c.sorted)+len(c.projection))
+	projected := map[string]bool{}
+	for _, col := range c.projection {
+		sorted = append(sorted, col.name)
+		projected[col.name] = true
+	}
+	for _, key := range c.sorted {
+		if projected[key] == false {
+			sorted = append(sorted, key)
+		}
+	}
+	c.sorted = sorted
+}
@@ -0,0 +1,103 @@
+package fjson2csv
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseProjection(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		spec     string
+		expected string
+	}{
+		{`id`, `[{id [id]}]`},
+		{`id, user.name as name, tags[0] as first_tag`, `[{id [id]} {name [user name]} {first_tag [tags 0]}]`},
+		{`.user.address.city`, `[{user.address.city [user address city]}]`},
+		{`."odd key", items[-1].price, ["a.b"][2]`, `[{odd key [odd key]} {items[-1].price [items -1 price]} {["a.b"][2] [a.b 2]}]`},
+		{` user.name  as  "full name" ,id `, `[{full name [user name]} {id [id]}]`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.spec, func(t *testing.T) {
+			p, err := parseProjection(tc.spec)
+			if err != nil {
+				t.Fatalf("parse failure: %s", err.Error())
+			}
+			if found := fmt.Sprint(p); found != tc.expected {
+				t.Errorf("expected %s, found %s", tc.expected, found)
+			}
+		})
+	}
+
+	failures := []struct {
+		spec     string
+		expected string
+	}{
+		{`id,`, "malformed projection: expected a property name at position 4"},
+		{`id name`, "malformed projection: expected ',' at position 4"},
+		{`tags[0`, "malformed projection: unterminated '[' at position 5"},
+		{`tags[x]`, "malformed projection: invalid index 'x' at position 5"},
+		{`user.`, "malformed projection: expected a property name at position 6"},
+		{`id as`, "malformed projection: expected ',' at position 4"},
+		{`id as ,`, "malformed projection: expected an alias at position 7"},
+		{`id, user.id as id`, "malformed projection: duplicate column 'id'"},
+	}
+	for _, tc := range failures {
+		t.Run(tc.spec, func(t *testing.T) {
+			_, err := parseProjection(tc.spec)
+			if err == nil || err.Error() != tc.expected {
+				t.Errorf("expected error '%s', found: %v", tc.expected, err)
+			}
+		})
+	}
+}
+
+func TestProjectionConvert(t *testing.T) {
+	t.Parallel()
+
+	raw := `[
+		{"id":1, "user":{"name":"Jane", "roles":["admin"]}, "tags":["a","b"], "extra":true},
+		{"id":2, "user":{"name":null}, "tags":[]},
+		{"id":3, "user":"unknown"}
+	]`
+
+	cases := []struct {
+		name     string
+		opts     Options
+		expected string
+	}{
+		{
+			"projection",
+			Options{Projection: `id, user.name as name, tags[0] as first_tag, user.roles[-1] as role, missing`},
+			"id,name,first_tag,role,missing\n1,Jane,a,admin,\n2,,,,\n3,,,,\n",
+		},
+		{
+			"objects and arrays",
+			Options{Projection: `id, user, tags, user.roles as roles`},
+			"id,user,tags,roles\n" +
+				`1,"{""name"":""Jane"",""roles"":[""admin""]}","[""a"",""b""]","[""admin""]"` + "\n" +
+				`2,"{""name"":null}",[],` + "\n" +
+				"3,unknown,,\n",
+		},
+		{
+			"missing and null",
+			Options{Projection: `user.name as name, id`, NullValue: "NULL", MissingValue: "-"},
+			"name,id\nJane,1\nNULL,2\n-,3\n",
+		},
+		{
+			"filtered and derived",
+			Options{
+				Projection:     `user.name as name, id`,
+				Filter:         `name != null`,
+				DerivedColumns: []DerivedColumn{{"label", `name + "#" + id`}},
+			},
+			"name,id,label\nJane,1,Jane#1\n",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			checkConversion(t, raw, tc.opts, tc.expected)
+		})
+	}
+}
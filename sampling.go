@@ -0,0 +1,106 @@
+package fjson2csv
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+)
+
+// Stops a conversion once `Options.Limit` rows have been written.
+var errLimitReached = errors.New("limit reached")
+
+/*
+ * Samples are drawn from the records selected by the filter, in input order,
+ * and are the same in every pass over the input. Bernoulli samples
+ * (`Options.SampleRate`) draw a random number for each record, from a
+ * generator seeded anew for each pass. Samples of a fixed size
+ * (`Options.SampleSize`) are chosen by reservoir sampling in a pass of their
+ * own, noting the numbers of the records sampled.
+ */
+type sampler struct {
+	rate float64
+	size int
+	seed int64
+	rng  *rand.Rand
+
+	// Number of the current record within a pass, and the numbers of the
+	// records in a fixed size sample
+	count  int64
+	chosen map[int64]bool
+}
+
+func newSampler(opts Options) (*sampler, error) {
+	if opts.SampleRate < 0 || opts.SampleRate > 1 {
+		return nil, fmt.Errorf("unsupported sample rate: %v", opts.SampleRate)
+	}
+	if opts.SampleSize < 0 {
+		return nil, fmt.Errorf("unsupported sample size: %d", opts.SampleSize)
+	}
+	if opts.SampleRate > 0 && opts.SampleSize > 0 {
+		return nil, fmt.Errorf("sample rate and sample size given together")
+	}
+	if opts.SampleRate == 0 && opts.SampleSize == 0 {
+		return nil, nil
+	}
+	s := &sampler{rate: opts.SampleRate, size: opts.SampleSize, seed: opts.SampleSeed}
+	s.rewind()
+	return s, nil
+}
+
+// Starts another pass over the records.
+func (s *sampler) rewind() {
+	s.count = 0
+	s.rng = rand.New(rand.NewSource(s.seed))
+}
+
+// Reports whether the next record is part of the sample.
+func (s *sampler) includes() bool {
+	s.count++
+	if s.size > 0 {
+		return s.chosen[s.count]
+	}
+	return s.rng.Float64() < s.rate
+}
+
+// Chooses the records of a fixed size sample, in a pass of its own.
+func (c *converter) chooseSample() {
+	if c.sample == nil || c.sample.size == 0 || c.err != nil {
+		return
+	}
+	reservoir := make([]int64, 0, c.sample.size)
+	c.WalkJsonList(func(record map[string]interface{}, args ...interface{}) error {
+		if c.selects(record) == false {
+			return nil
+		}
+		c.sample.count++
+		if len(reservoir) < c.sample.size {
+			reservoir = append(reservoir, c.sample.count)
+		} else if i := c.sample.rng.Int63n(c.sample.count); i < int64(c.sample.size) {
+			reservoir[i] = c.sample.count
+		}
+		return nil
+	})
+	c.sample.chosen = make(map[int64]bool, len(reservoir))
+	for _, n := range reservoir {
+		c.sample.chosen[n] = true
+	}
+}
+
+// Reports whether a record is selected by the filter, and part of the sample
+// (if any).
+func (c *converter) includes(record map[string]interface{}) bool {
+	if c.selects(record) == false {
+		return false
+	}
+	return c.sample == nil || c.sample.includes()
+}
+
+// Reports whether the next row is written, given the offset and limit of the
+// output. Returns `errLimitReached` once the limit has been written.
+func (c *converter) countRow() (bool, error) {
+	c.rows++
+	if c.limit > 0 && c.rows > int64(c.offset+c.limit) {
+		return false, errLimitReached
+	}
+	return c.rows > int64(c.offset), nil
+}
@@ -0,0 +1,113 @@
+package fjson2csv
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestLimitConvert(t *testing.T) {
+	t.Parallel()
+
+	raw := `[
+		{"id":1, "score":30},
+		{"id":2, "score":10},
+		{"id":3, "score":50},
+		{"id":4, "score":20},
+		{"id":5, "score":40}
+	]`
+
+	cases := []struct {
+		name     string
+		opts     Options
+		expected string
+	}{
+		{"limit", Options{Limit: 2}, "id,score\n1,30\n2,10\n"},
+		{"offset", Options{Offset: 3}, "id,score\n4,20\n5,40\n"},
+		{"offset and limit", Options{Offset: 1, Limit: 2}, "id,score\n2,10\n3,50\n"},
+		{"beyond the end", Options{Offset: 4, Limit: 10}, "id,score\n5,40\n"},
+		{"sorted", Options{SortBy: []string{"-score"}, Limit: 2}, "id,score\n3,50\n5,40\n"},
+		{"filtered", Options{Filter: "score > 15", Offset: 1, Limit: 2}, "id,score\n3,50\n4,20\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			checkConversion(t, raw, tc.opts, tc.expected)
+		})
+	}
+}
+
+func TestSampleConvert(t *testing.T) {
+	t.Parallel()
+
+	// Each record has a column of its own, so the header shows which were
+	// sampled
+	records := []string{}
+	for i := 1; i <= 50; i++ {
+		records = append(records, fmt.Sprintf(`{"id":%d, "k%02d":true}`, i, i))
+	}
+	raw := "[" + strings.Join(records, ",") + "]"
+
+	cases := []struct {
+		name     string
+		opts     Options
+		rows     int
+		willFail bool
+	}{
+		{"rate", Options{SampleRate: 0.2, SampleSeed: 7, FilterColumns: true}, -1, false},
+		{"all", Options{SampleRate: 1, FilterColumns: true}, 50, false},
+		{"size", Options{SampleSize: 5, SampleSeed: 7, FilterColumns: true}, 5, false},
+		{"size beyond records", Options{SampleSize: 80, FilterColumns: true}, 50, false},
+		{"filtered", Options{SampleSize: 5, Filter: "id > 40", FilterColumns: true}, 5, false},
+		{"invalid rate", Options{SampleRate: 1.5}, 0, true},
+		{"invalid size", Options{SampleSize: -1}, 0, true},
+		{"rate and size", Options{SampleRate: 0.5, SampleSize: 5}, 0, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			outputs := map[string]string{}
+			for name, convert := range conversions {
+				buffer := bytes.Buffer{}
+				err := convert(strings.NewReader(raw), &buffer, tc.opts)
+				if (err != nil) != tc.willFail {
+					t.Fatalf("expected failure: %t, found: %v", tc.willFail, err)
+				}
+				outputs[name] = buffer.String()
+			}
+			if tc.willFail == true {
+				return
+			}
+			if outputs["buffered"] != outputs["unbuffered"] {
+				t.Logf("buffered and unbuffered samples differ")
+				t.Logf("Buffered:\n%s", outputs["buffered"])
+				t.Logf("Unbuffered:\n%s", outputs["unbuffered"])
+				t.FailNow()
+			}
+
+			lines := strings.Split(strings.TrimSpace(outputs["buffered"]), "\n")
+			rows := len(lines) - 1
+			if tc.rows >= 0 && rows != tc.rows {
+				t.Fatalf("expected %d sampled rows, found %d", tc.rows, rows)
+			}
+			if tc.rows < 0 && (rows == 0 || rows == 50) {
+				t.Fatalf("expected a partial sample, found %d rows", rows)
+			}
+			if columns := strings.Split(lines[0], ","); len(columns) != rows+1 {
+				t.Fatalf("expected columns of the %d sampled rows only, found %s", rows, lines[0])
+			}
+		})
+	}
+
+	// Samples depend on their seed only
+	sample := func(seed int64) string {
+		buffer := bytes.Buffer{}
+		UnbufferedConvert(strings.NewReader(raw), &buffer, Options{SampleSize: 5, SampleSeed: seed})
+		return buffer.String()
+	}
+	if sample(1) != sample(1) {
+		t.Errorf("expected samples with the same seed to be the same")
+	}
+	if sample(1) == sample(2) {
+		t.Errorf("expected samples with different seeds to differ")
+	}
+}
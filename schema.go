@@ -0,0 +1,433 @@
+package fjson2csv
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// Formats of inferred schemas.
+const (
+	JsonSchemaFormat string = "jsonschema"
+	ManifestFormat   string = "manifest"
+)
+
+const json_schema_dialect string = "https://json-schema.org/draft/2020-12/schema"
+
+// Policies for values which violate the type of their column in a schema, or
+// for fields whose values have conflicting types.
+const (
+	// Converts values to the column's type where possible (eg. numeric
+	// strings in number columns), and otherwise writes them as null
+	CoercePolicy string = "coerce"
+	// Writes conflicting values as strings
+	StringPolicy string = "string"
+	// Writes conflicting values as numbers, where possible, and otherwise
+	// as null
+	NumberPolicy string = "number"
+	// Coerces values (conflicting values to strings), and reports each
+	// violation or conflict
+	ReportPolicy string = "report"
+	// Stops the conversion at the first violation or conflict
+	FailPolicy string = "fail"
+)
+
+// Infers the schema of the input's records, written as a JSON Schema document
+// (`JsonSchemaFormat`, the default) or a column manifest (`ManifestFormat`).
+//
+// Fields are described in column order, along with the lengths of their
+// shortest and longest string values and a few example values. JSON Schema
+// documents give the JSON types seen for each field, and require the fields
+// present in every record. Column manifests give the type of each output
+// column and whether it is nullable, along with the conflicting types of
+// fields whose values have more than one (see `Options.ConflictPolicy`).
+func InferSchema(r io.ReadSeeker, w io.Writer, opts Options) error {
+	format := opts.Format
+	if format == "" {
+		format = JsonSchemaFormat
+	}
+	if format != JsonSchemaFormat && format != ManifestFormat {
+		return fmt.Errorf("unsupported schema format: %s", format)
+	}
+
+	c := newConverter(r, w, opts)
+	var err error
+	if c.input, err = newRecordSource(r, opts); err != nil {
+		return err
+	}
+	if err = c.parseExpressions(opts); err != nil {
+		return err
+	}
+	c.IndexFields(extractKeys)
+	if c.err != nil {
+		return c.err
+	}
+
+	compact := bytes.Buffer{}
+	if format == JsonSchemaFormat {
+		err = c.jsonSchema().encode(&compact)
+	} else {
+		err = c.manifest(&compact)
+	}
+	if err != nil {
+		return err
+	}
+
+	indented := bytes.Buffer{}
+	if err := json.Indent(&indented, compact.Bytes(), "", "  "); err != nil {
+		return err
+	}
+	indented.WriteByte('\n')
+	_, err = indented.WriteTo(w)
+	return err
+}
+
+// Describes the indexed fields as a JSON Schema document.
+func (c *converter) jsonSchema() *orderedObject {
+	schema := newOrderedObject()
+	schema.set([]string{"$schema"}, json_schema_dialect)
+	schema.set([]string{"type"}, "object")
+
+	properties := newOrderedObject()
+	required := []string{}
+	for _, key := range c.sorted {
+		stats := c.stats(key)
+		property := newOrderedObject()
+		if types := jsonTypes(stats.types); len(types) == 1 {
+			property.set([]string{"type"}, types[0])
+		} else {
+			property.set([]string{"type"}, types)
+		}
+		describeStats(property, stats)
+		properties.set([]string{key}, property)
+
+		if c.Keys[key] == c.records {
+			required = append(required, key)
+		}
+	}
+	schema.set([]string{"properties"}, properties)
+	schema.set([]string{"required"}, required)
+	return schema
+}
+
+// Describes the output columns as a JSON array of objects.
+func (c *converter) manifest(buffer *bytes.Buffer) error {
+	buffer.WriteByte('[')
+	for i, col := range c.columns() {
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+		column := newOrderedObject()
+		column.set([]string{"name"}, col.Name)
+		column.set([]string{"type"}, col.Type.String())
+		column.set([]string{"nullable"}, col.Nullable)
+		stats := c.stats(col.Name)
+		if conflicting(stats.types) {
+			column.set([]string{"conflicts"}, jsonTypes(stats.types&^nullType))
+		}
+		describeStats(column, stats)
+		if err := column.encode(buffer); err != nil {
+			return err
+		}
+	}
+	buffer.WriteByte(']')
+	return nil
+}
+
+// Adds string lengths and examples of a field's values to its description.
+func describeStats(description *orderedObject, stats *fieldStats) {
+	if stats.minLength >= 0 {
+		description.set([]string{"minLength"}, stats.minLength)
+		description.set([]string{"maxLength"}, stats.maxLength)
+	}
+	if len(stats.examples) > 0 {
+		description.set([]string{"examples"}, stats.examples)
+	}
+}
+
+// Names the JSON types of a set of type bits. Integers are only named when
+// no fractional numbers were seen, since JSON Schema numbers include them.
+func jsonTypes(types uint8) []string {
+	names := []string{}
+	if types&stringType != 0 {
+		names = append(names, "string")
+	}
+	if types&floatType != 0 {
+		names = append(names, "number")
+	} else if types&intType != 0 {
+		names = append(names, "integer")
+	}
+	if types&boolType != 0 {
+		names = append(names, "boolean")
+	}
+	if types&objectType != 0 {
+		names = append(names, "object")
+	}
+	if types&arrayType != 0 {
+		names = append(names, "array")
+	}
+	if types&nullType != 0 {
+		names = append(names, "null")
+	}
+	return names
+}
+
+// Reads output columns from a JSON Schema document or a column manifest, as
+// written by `InferSchema`.
+//
+// Columns of a JSON Schema are its properties, in order. Their types come
+// from the JSON types they allow, and they are nullable when they allow null
+// or are not required. Columns of a manifest are given as objects with a
+// "name", "type" (one of "string", "integer", "number" or "boolean") and
+// "nullable" flag.
+func ReadSchema(r io.Reader) ([]Column, error) {
+	dec := json.NewDecoder(r)
+	token, err := dec.Token()
+	if err != nil {
+		return nil, fmt.Errorf("malformed schema: %s", err.Error())
+	}
+	delim, _ := token.(json.Delim)
+	switch delim.String() {
+	case "[":
+		return readManifest(dec)
+	case "{":
+		return readJsonSchema(dec)
+	}
+	return nil, fmt.Errorf("malformed schema: expected a JSON Schema document or a column manifest")
+}
+
+// Reads the columns of a manifest, following its opening bracket.
+func readManifest(dec *json.Decoder) ([]Column, error) {
+	columns := []Column{}
+	for dec.More() {
+		var entry struct {
+			Name     string `json:"name"`
+			Type     string `json:"type"`
+			Nullable bool   `json:"nullable"`
+		}
+		if err := dec.Decode(&entry); err != nil {
+			return nil, fmt.Errorf("malformed schema: %s", err.Error())
+		}
+		if entry.Name == "" {
+			return nil, fmt.Errorf("malformed schema: column %d has no name", len(columns)+1)
+		}
+		col := Column{Name: entry.Name, Nullable: entry.Nullable}
+		switch entry.Type {
+		case "", "string":
+			col.Type = StringColumn
+		case "integer":
+			col.Type = IntegerColumn
+		case "number":
+			col.Type = FloatColumn
+		case "boolean":
+			col.Type = BooleanColumn
+		default:
+			return nil, fmt.Errorf("malformed schema: column '%s': unsupported type '%s'", entry.Name, entry.Type)
+		}
+		columns = append(columns, col)
+	}
+	return columns, nil
+}
+
+// Reads the columns of a JSON Schema document, following its opening brace.
+func readJsonSchema(dec *json.Decoder) ([]Column, error) {
+	var properties Record
+	required := []string{}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("malformed schema: %s", err.Error())
+		}
+		switch key {
+		case "properties":
+			// Keep properties in order, as they name the columns
+			if properties, err = decodeRecord(dec); err != nil {
+				return nil, fmt.Errorf("malformed schema: properties must be an object")
+			}
+		case "required":
+			if err := dec.Decode(&required); err != nil {
+				return nil, fmt.Errorf("malformed schema: required must be a list of names")
+			}
+		default:
+			if err := skipValue(dec); err != nil {
+				return nil, fmt.Errorf("malformed schema")
+			}
+		}
+	}
+	if properties == nil {
+		return nil, fmt.Errorf("malformed schema: missing properties")
+	}
+
+	columns := []Column{}
+	for _, property := range properties {
+		col := Column{Name: property.Key, Nullable: true}
+		for _, name := range required {
+			if name == property.Key {
+				col.Nullable = false
+			}
+		}
+
+		definition, _ := property.Value.(map[string]interface{})
+		types := []interface{}{definition["type"]}
+		if list, ok := definition["type"].([]interface{}); ok == true {
+			types = list
+		}
+		var kinds uint8
+		for _, name := range types {
+			switch name {
+			case "null":
+				col.Nullable = true
+			case "integer":
+				kinds |= intType
+			case "number":
+				kinds |= floatType
+			case "boolean":
+				kinds |= boolType
+			default:
+				kinds |= stringType
+			}
+		}
+		switch kinds {
+		case intType:
+			col.Type = IntegerColumn
+		case floatType, intType | floatType:
+			col.Type = FloatColumn
+		case boolType:
+			col.Type = BooleanColumn
+		}
+		columns = append(columns, col)
+	}
+	return columns, nil
+}
+
+// Checks records against the columns of a schema, coercing values which
+// violate their column's type.
+type schemaChecker struct {
+	columns []Column
+	output  []Column
+	policy  string
+	log     io.Writer
+	records int64
+}
+
+// Uses the columns of the schema in the options, rather than indexing them.
+func (c *converter) useSchema(opts Options) {
+	checker := &schemaChecker{columns: opts.Schema, policy: opts.SchemaPolicy, log: c.log}
+	switch checker.policy {
+	case "":
+		checker.policy = CoercePolicy
+	case CoercePolicy, ReportPolicy, FailPolicy:
+	default:
+		c.err = fmt.Errorf("unsupported schema policy: %s", checker.policy)
+		return
+	}
+	c.schema = checker
+
+	// Coercion writes null for missing values and those it can't convert, so
+	// columns are only written as required when violations fail instead
+	checker.output = make([]Column, len(opts.Schema))
+	copy(checker.output, opts.Schema)
+	if checker.policy != FailPolicy {
+		for i := range checker.output {
+			checker.output[i].Nullable = true
+		}
+	}
+
+	c.sorted = make([]string, len(opts.Schema))
+	for i, col := range opts.Schema {
+		c.sorted[i] = col.Name
+	}
+}
+
+// Checks the values of a record, replacing those which violate their
+// column's type with coerced values.
+func (s *schemaChecker) check(record map[string]interface{}) error {
+	s.records++
+	for _, col := range s.columns {
+		value, ok := record[col.Name]
+		coerced, valid := coerce(col, value, ok)
+		if valid == true {
+			continue
+		}
+
+		found := "nothing"
+		if ok == true {
+			found = jsonTypes(typeOf(value))[0]
+			if typeOf(value)&(stringType|intType|floatType|boolType) != 0 {
+				encoded, _ := json.Marshal(value)
+				found += " " + string(encoded)
+			}
+		}
+		violation := fmt.Sprintf("record %d: column '%s': expected %s, found %s", s.records, col.Name, describeColumn(col), found)
+		switch s.policy {
+		case FailPolicy:
+			return fmt.Errorf("schema violation: %s", violation)
+		case ReportPolicy:
+			fmt.Fprintf(s.log, "%s\n", violation)
+		}
+		if ok == true {
+			record[col.Name] = coerced
+		}
+	}
+	return nil
+}
+
+// Describes the values a column accepts.
+func describeColumn(col Column) string {
+	if col.Nullable {
+		return col.Type.String() + " or null"
+	}
+	return col.Type.String()
+}
+
+// Coerces a value to the type of a column. Reports false when the value (or
+// its absence) violates the column's type, in which case the value is
+// converted where possible and is otherwise null.
+func coerce(col Column, value interface{}, present bool) (interface{}, bool) {
+	if present == false || value == nil {
+		return nil, col.Nullable
+	}
+
+	switch col.Type {
+	case IntegerColumn:
+		if v, ok := value.(float64); ok == true {
+			return math.Trunc(v), typeOf(v) == intType
+		}
+		if v, ok := value.(string); ok == true {
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return float64(n), false
+			}
+		}
+	case FloatColumn:
+		if _, ok := value.(float64); ok == true {
+			return value, true
+		}
+		if v, ok := value.(string); ok == true {
+			if n, err := strconv.ParseFloat(v, 64); err == nil {
+				return n, false
+			}
+		}
+	case BooleanColumn:
+		if _, ok := value.(bool); ok == true {
+			return value, true
+		}
+		if v, ok := value.(string); ok == true {
+			if b, err := strconv.ParseBool(v); err == nil {
+				return b, false
+			}
+		}
+	default:
+		switch v := value.(type) {
+		case string:
+			return v, true
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), false
+		case bool:
+			return strconv.FormatBool(v), false
+		}
+	}
+	return nil, false
+}
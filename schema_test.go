@@ -0,0 +1,346 @@
+package fjson2csv
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestInferSchema(t *testing.T) {
+	t.Parallel()
+
+	raw := `[
+		{"id":1, "name":"Jane", "score":9.5, "tags":["a"]},
+		{"id":2, "name":"Jo", "score":7, "active":true},
+		{"id":3, "name":null, "score":7}
+	]`
+
+	cases := []struct {
+		name     string
+		input    string
+		opts     Options
+		expected string
+	}{
+		{
+			"json schema",
+			raw,
+			Options{},
+			`{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "type": "object",
+  "properties": {
+    "id": {
+      "type": "integer",
+      "examples": [
+        1,
+        2,
+        3
+      ]
+    },
+    "name": {
+      "type": [
+        "string",
+        "null"
+      ],
+      "minLength": 2,
+      "maxLength": 4,
+      "examples": [
+        "Jane",
+        "Jo"
+      ]
+    },
+    "score": {
+      "type": "number",
+      "examples": [
+        9.5,
+        7
+      ]
+    },
+    "active": {
+      "type": "boolean",
+      "examples": [
+        true
+      ]
+    },
+    "tags": {
+      "type": "array"
+    }
+  },
+  "required": [
+    "id",
+    "name",
+    "score"
+  ]
+}
+`,
+		},
+		{
+			"manifest",
+			raw,
+			Options{Format: ManifestFormat},
+			`[
+  {
+    "name": "id",
+    "type": "integer",
+    "nullable": false,
+    "examples": [
+      1,
+      2,
+      3
+    ]
+  },
+  {
+    "name": "name",
+    "type": "string",
+    "nullable": true,
+    "minLength": 2,
+    "maxLength": 4,
+    "examples": [
+      "Jane",
+      "Jo"
+    ]
+  },
+  {
+    "name": "score",
+    "type": "number",
+    "nullable": false,
+    "examples": [
+      9.5,
+      7
+    ]
+  },
+  {
+    "name": "active",
+    "type": "boolean",
+    "nullable": true,
+    "examples": [
+      true
+    ]
+  },
+  {
+    "name": "tags",
+    "type": "string",
+    "nullable": true
+  }
+]
+`,
+		},
+		{
+			"manifest conflicts",
+			`[{"zip":"02134"}, {"zip":2134}, {"zip":"n/a"}]`,
+			Options{Format: ManifestFormat, ColumnPolicies: map[string]string{"zip": NumberPolicy}},
+			`[
+  {
+    "name": "zip",
+    "type": "integer",
+    "nullable": true,
+    "conflicts": [
+      "string",
+      "integer"
+    ],
+    "minLength": 3,
+    "maxLength": 5,
+    "examples": [
+      "02134",
+      2134,
+      "n/a"
+    ]
+  }
+]
+`,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			buffer := bytes.Buffer{}
+			if err := InferSchema(strings.NewReader(tc.input), &buffer, tc.opts); err != nil {
+				t.Fatalf("inference failure: %s", err.Error())
+			}
+			if buffer.String() != tc.expected {
+				t.Logf("schema did not match")
+				t.Logf("Expected:\n%s", tc.expected)
+				t.Logf("Found:\n%s", buffer.String())
+				t.FailNow()
+			}
+		})
+	}
+}
+
+func TestInferSchemaFailure(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		raw  string
+		opts Options
+	}{
+		{"unknown format", `[]`, Options{Format: CsvFormat}},
+		{"malformed input", `{}`, Options{}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := InferSchema(strings.NewReader(tc.raw), &bytes.Buffer{}, tc.opts); err == nil {
+				t.Errorf("expected inference to fail")
+			}
+		})
+	}
+}
+
+func TestReadSchema(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		raw      string
+		expected []Column
+		willFail bool
+	}{
+		{
+			"manifest",
+			`[{"name":"id","type":"integer"}, {"name":"ratio","type":"number","nullable":true}, {"name":"note"}, {"name":"ok","type":"boolean"}]`,
+			[]Column{{"id", IntegerColumn, false}, {"ratio", FloatColumn, true}, {"note", StringColumn, false}, {"ok", BooleanColumn, false}},
+			false,
+		},
+		{
+			"json schema",
+			`{"$schema":"x", "properties":{"z":{"type":"integer"}, "a":{"type":["number","null"]}, "m":{"type":["integer","number"]}, "s":{}, "b":{"type":"boolean"}}, "required":["z","m","s"]}`,
+			[]Column{{"z", IntegerColumn, false}, {"a", FloatColumn, true}, {"m", FloatColumn, false}, {"s", StringColumn, false}, {"b", BooleanColumn, true}},
+			false,
+		},
+		{"unsupported type", `[{"name":"id","type":"date"}]`, nil, true},
+		{"unnamed column", `[{"type":"integer"}]`, nil, true},
+		{"missing properties", `{"type":"object"}`, nil, true},
+		{"not a schema", `"columns"`, nil, true},
+		{"malformed", `[{"name":`, nil, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			columns, err := ReadSchema(strings.NewReader(tc.raw))
+			if (err != nil) != tc.willFail {
+				t.Fatalf("expected failure: %t, found: %v", tc.willFail, err)
+			}
+			if fmt.Sprint(columns) != fmt.Sprint(tc.expected) && tc.willFail == false {
+				t.Errorf("expected %v, found %v", tc.expected, columns)
+			}
+		})
+	}
+
+	// Inferred schemas can be read back
+	raw := `[{"id":1, "name":"a"}, {"id":2, "ratio":0.5}]`
+	expected := []Column{{"id", IntegerColumn, false}, {"name", StringColumn, true}, {"ratio", FloatColumn, true}}
+	for _, format := range []string{JsonSchemaFormat, ManifestFormat} {
+		buffer := bytes.Buffer{}
+		if err := InferSchema(strings.NewReader(raw), &buffer, Options{Format: format}); err != nil {
+			t.Fatalf("inference failure: %s", err.Error())
+		}
+		columns, err := ReadSchema(&buffer)
+		if err != nil || fmt.Sprint(columns) != fmt.Sprint(expected) {
+			t.Errorf("%s: expected %v, found %v (%v)", format, expected, columns, err)
+		}
+	}
+}
+
+func TestSchemaConvert(t *testing.T) {
+	t.Parallel()
+
+	raw := `[
+		{"name":"Jane", "id":1, "extra":true},
+		{"id":"2", "ok":"true"},
+		{"id":3.5, "ok":1, "name":7}
+	]`
+	schema := []Column{{"id", IntegerColumn, false}, {"name", StringColumn, false}, {"ok", BooleanColumn, true}}
+
+	cases := []struct {
+		name       string
+		policy     string
+		expected   string
+		violations string
+		willFail   bool
+	}{
+		{"coerce", CoercePolicy, "id,name,ok\n1,Jane,\n2,,true\n3,7,\n", "", false},
+		{"report", ReportPolicy, "id,name,ok\n1,Jane,\n2,,true\n3,7,\n", `record 2: column 'id': expected integer, found string "2"
+record 2: column 'name': expected string, found nothing
+record 2: column 'ok': expected boolean or null, found string "true"
+record 3: column 'id': expected integer, found number 3.5
+record 3: column 'name': expected string, found integer 7
+record 3: column 'ok': expected boolean or null, found integer 1
+`, false},
+		{"fail", FailPolicy, "", "", true},
+		{"unknown policy", "ignore", "", "", true},
+	}
+	for _, tc := range cases {
+		for name, convert := range conversions {
+			t.Run(tc.name+" "+name, func(t *testing.T) {
+				// Records are only read once, so the input needn't be seekable
+				reader := badSeeker{strings.NewReader(raw)}
+				buffer := bytes.Buffer{}
+				violations := bytes.Buffer{}
+				opts := Options{Schema: schema, SchemaPolicy: tc.policy, ViolationLog: &violations}
+				err := convert(reader, &buffer, opts)
+				if (err != nil) != tc.willFail {
+					t.Fatalf("expected failure: %t, found: %v", tc.willFail, err)
+				}
+				if tc.willFail {
+					return
+				}
+				checkOutput(t, "conversion", tc.expected, buffer.String())
+				if violations.String() != tc.violations {
+					t.Logf("unexpected violations")
+					t.Logf("Expected:\n%s", tc.violations)
+					t.Logf("Found:\n%s", violations.String())
+					t.FailNow()
+				}
+			})
+		}
+	}
+}
+
+func TestSchemaRequiredColumns(t *testing.T) {
+	raw := `[
+		{"id":1, "name":"Jane"},
+		{"id":2},
+		{"id":"x", "name":null}
+	]`
+	schema := []Column{{"id", IntegerColumn, false}, {"name", StringColumn, false}}
+
+	// Parquet
+	for _, policy := range []string{CoercePolicy, ReportPolicy} {
+		buffer := bytes.Buffer{}
+		opts := Options{Schema: schema, SchemaPolicy: policy, Format: ParquetFormat, ViolationLog: io.Discard}
+		if err := BufferedConvert(strings.NewReader(raw), &buffer, opts); err != nil {
+			t.Fatalf("%s: conversion failure: %s", policy, err.Error())
+		}
+		columns, rows := readParquet(t, buffer.Bytes())
+		if found := describeParquetColumns(columns); found != "id:2:optional,name:6:optional" {
+			t.Errorf("%s: unexpected columns %s", policy, found)
+		}
+		if found := describeParquetRows(columns, rows); found != "1 Jane|2 <nil>|<nil> <nil>" {
+			t.Errorf("%s: unexpected rows %s", policy, found)
+		}
+	}
+	opts := Options{Schema: schema, SchemaPolicy: FailPolicy, Format: ParquetFormat}
+	if err := BufferedConvert(strings.NewReader(raw), &bytes.Buffer{}, opts); err == nil {
+		t.Errorf("expected missing required values to fail")
+	}
+
+	// SQLite
+	sqliteRecorder.Lock()
+	sqliteRecorder.log = nil
+	sqliteRecorder.Unlock()
+	enc := newSqliteEncoder(&bytes.Buffer{}, "", 0)
+	enc.driver = "fjson2csv-recorder"
+	opts = Options{Schema: schema, Encoder: enc}
+	if err := BufferedConvert(strings.NewReader(raw), &bytes.Buffer{}, opts); err != nil {
+		t.Fatalf("sqlite conversion failure: %s", err.Error())
+	}
+	create := `CREATE TABLE "records" ("id" INTEGER, "name" TEXT) []`
+	if strings.Contains(strings.Join(sqliteRecorder.log, "\n"), create) == false {
+		t.Errorf("expected %s, found %v", create, sqliteRecorder.log)
+	}
+	insert := `INSERT INTO "records" VALUES (?, ?) [2 <nil>]`
+	if strings.Contains(strings.Join(sqliteRecorder.log, "\n"), insert) == false {
+		t.Errorf("expected %s, found %v", insert, sqliteRecorder.log)
+	}
+}
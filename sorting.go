@@ -0,0 +1,312 @@
+package fjson2csv
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Records held in memory while sorting, when `Options.SortMemory` isn't given.
+const default_sort_memory int = 100000
+
+// Temporary files merged at once. More runs are merged in several passes, to
+// keep few files open.
+const sort_merge_width int = 64
+
+/*
+ * Rows are sorted as they would be written, after filtering and finding
+ * duplicates. Up to `Options.SortMemory` records are sorted in memory. Beyond
+ * that, each batch of sorted records is written to a temporary file (as
+ * newline delimited JSON), and the files are merged once every record has been
+ * read, up to `sort_merge_width` of them at a time. Sorts are stable, so
+ * records with equal keys keep their input order.
+ */
+type sorter struct {
+	keys  []sortKey
+	limit int
+	width int
+	rows  []sortedRow
+	runs  []*os.File
+}
+
+// A column records are sorted by, and its direction.
+type sortKey struct {
+	name       string
+	descending bool
+	column     Column
+}
+
+// A record and the values of its sort keys.
+type sortedRow struct {
+	record map[string]interface{}
+	values []interface{}
+}
+
+func newSorter(opts Options) (*sorter, error) {
+	if len(opts.SortBy) == 0 {
+		return nil, nil
+	}
+	s := &sorter{limit: opts.SortMemory, width: sort_merge_width}
+	if s.limit < 1 {
+		s.limit = default_sort_memory
+	}
+	for _, spec := range opts.SortBy {
+		key := sortKey{name: strings.TrimSpace(spec)}
+		if strings.HasPrefix(key.name, "-") {
+			key.name, key.descending = key.name[1:], true
+		} else {
+			key.name = strings.TrimPrefix(key.name, "+")
+		}
+		if key.name == "" {
+			return nil, fmt.Errorf("malformed sort column: '%s'", spec)
+		}
+		s.keys = append(s.keys, key)
+	}
+	return s, nil
+}
+
+// Finds the types of the sort columns among the output's columns.
+func (s *sorter) prepare(columns []Column) error {
+	for i, key := range s.keys {
+		found := false
+		for _, col := range columns {
+			if col.Name == key.name {
+				s.keys[i].column, found = col, true
+				break
+			}
+		}
+		if found == false {
+			return fmt.Errorf("unsupported sort column: %s", key.name)
+		}
+	}
+	return nil
+}
+
+// Adds a record to be sorted.
+func (s *sorter) add(record map[string]interface{}) error {
+	s.rows = append(s.rows, s.row(record))
+	if len(s.rows) >= s.limit {
+		return s.spill()
+	}
+	return nil
+}
+
+// Returns a record with the values of its sort keys, as typed by their
+// columns. Numbers and booleans compare as numbers, and other values as text.
+func (s *sorter) row(record map[string]interface{}) sortedRow {
+	row := sortedRow{record: record, values: make([]interface{}, len(s.keys))}
+	for i, key := range s.keys {
+		switch v := key.column.Convert(record[key.name]).(type) {
+		case int64:
+			row.values[i] = float64(v)
+		case bool:
+			if v {
+				row.values[i] = float64(1)
+			} else {
+				row.values[i] = float64(0)
+			}
+		default:
+			row.values[i] = v
+		}
+	}
+	return row
+}
+
+// Reports whether a row sorts before another. Null (and missing) values sort
+// after any other value, in either direction.
+func (s *sorter) less(a sortedRow, b sortedRow) bool {
+	for i, key := range s.keys {
+		x, y := a.values[i], b.values[i]
+		switch {
+		case x == nil && y == nil:
+			continue
+		case x == nil:
+			return false
+		case y == nil:
+			return true
+		}
+		order, _ := compareValues(x, y)
+		if order == 0 {
+			continue
+		}
+		if key.descending {
+			order = -order
+		}
+		return order < 0
+	}
+	return false
+}
+
+// Writes the rows in memory to a temporary file, in order.
+func (s *sorter) spill() error {
+	sort.SliceStable(s.rows, func(i, j int) bool {
+		return s.less(s.rows[i], s.rows[j])
+	})
+	run, err := s.writeRun(func(write func(map[string]interface{}) error) error {
+		for _, row := range s.rows {
+			if err := write(row.record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, run)
+	s.rows = nil
+	return nil
+}
+
+// Writes the records passed by a function to a new temporary file (as
+// newline delimited JSON), which is removed again on failure.
+func (s *sorter) writeRun(fill func(write func(map[string]interface{}) error) error) (*os.File, error) {
+	file, err := os.CreateTemp("", "fjson2csv-sort-*")
+	if err != nil {
+		return nil, fmt.Errorf("sort failure: %s", err.Error())
+	}
+	w := bufio.NewWriter(file)
+	enc := json.NewEncoder(w)
+	err = fill(func(record map[string]interface{}) error {
+		if err := enc.Encode(record); err != nil {
+			return fmt.Errorf("sort failure: %s", err.Error())
+		}
+		return nil
+	})
+	if err == nil {
+		if err = w.Flush(); err != nil {
+			err = fmt.Errorf("sort failure: %s", err.Error())
+		}
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return file, nil
+}
+
+// Passes every record to a function, in order.
+func (s *sorter) flush(fn func(map[string]interface{}) error) error {
+	if len(s.runs) == 0 {
+		sort.SliceStable(s.rows, func(i, j int) bool {
+			return s.less(s.rows[i], s.rows[j])
+		})
+		for _, row := range s.rows {
+			if err := fn(row.record); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(s.rows) > 0 {
+		if err := s.spill(); err != nil {
+			return err
+		}
+	}
+
+	// Merge the earliest runs into one until few enough are left to merge
+	// at once. The merged run takes their place, so ties keep input order.
+	for len(s.runs) > s.width {
+		merged, err := s.writeRun(func(write func(map[string]interface{}) error) error {
+			return s.merge(s.runs[:s.width], write)
+		})
+		if err != nil {
+			return err
+		}
+		for _, run := range s.runs[:s.width] {
+			run.Close()
+			os.Remove(run.Name())
+		}
+		s.runs = append([]*os.File{merged}, s.runs[s.width:]...)
+	}
+	return s.merge(s.runs, fn)
+}
+
+// Passes the records of sorted runs to a function, in order, taking the
+// earliest run's record among equal ones.
+func (s *sorter) merge(runs []*os.File, fn func(map[string]interface{}) error) error {
+	heads := &runHeads{sorter: s}
+	for i, run := range runs {
+		if _, err := run.Seek(0, 0); err != nil {
+			return fmt.Errorf("sort failure: %s", err.Error())
+		}
+		head := &runHead{run: i, decoder: json.NewDecoder(bufio.NewReader(run))}
+		if ok, err := head.advance(s); err != nil {
+			return err
+		} else if ok == true {
+			heads.heads = append(heads.heads, head)
+		}
+	}
+	heap.Init(heads)
+	for heads.Len() > 0 {
+		head := heads.heads[0]
+		if err := fn(head.row.record); err != nil {
+			return err
+		}
+		if ok, err := head.advance(s); err != nil {
+			return err
+		} else if ok == true {
+			heap.Fix(heads, 0)
+		} else {
+			heap.Pop(heads)
+		}
+	}
+	return nil
+}
+
+// The next record of a sorted run being merged.
+type runHead struct {
+	row     sortedRow
+	run     int
+	decoder *json.Decoder
+}
+
+// Reads the next record of the run, reporting whether there was one.
+func (h *runHead) advance(s *sorter) (bool, error) {
+	record := map[string]interface{}{}
+	if err := h.decoder.Decode(&record); err == io.EOF {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("sort failure: %s", err.Error())
+	}
+	h.row = s.row(record)
+	return true, nil
+}
+
+// A heap of the runs being merged, by their next record (see
+// `container/heap`).
+type runHeads struct {
+	sorter *sorter
+	heads  []*runHead
+}
+
+func (h *runHeads) Len() int { return len(h.heads) }
+func (h *runHeads) Less(i, j int) bool {
+	a, b := h.heads[i], h.heads[j]
+	if h.sorter.less(a.row, b.row) {
+		return true
+	}
+	return h.sorter.less(b.row, a.row) == false && a.run < b.run
+}
+func (h *runHeads) Swap(i, j int)      { h.heads[i], h.heads[j] = h.heads[j], h.heads[i] }
+func (h *runHeads) Push(x interface{}) { h.heads = append(h.heads, x.(*runHead)) }
+func (h *runHeads) Pop() interface{} {
+	last := h.heads[len(h.heads)-1]
+	h.heads = h.heads[:len(h.heads)-1]
+	return last
+}
+
+// Removes the temporary files of the sort.
+func (s *sorter) close() {
+	for _, run := range s.runs {
+		run.Close()
+		os.Remove(run.Name())
+	}
+	s.runs = nil
+}
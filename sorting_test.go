@@ -0,0 +1,193 @@
+package fjson2csv
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSortMissingValues(t *testing.T) {
+	t.Parallel()
+
+	// Null and missing values sort last, whatever the direction
+	raw := `[
+		{"id":1, "score":3},
+		{"id":2},
+		{"id":3, "score":null},
+		{"id":4, "score":7},
+		{"id":5, "score":5}
+	]`
+	for name, memory := range map[string]int{"in memory": 0, "spilled": 1} {
+		for _, sortBy := range []string{"score", "-score"} {
+			expected := "id,score\n1,3\n5,5\n4,7\n2,\n3,\n"
+			if sortBy == "-score" {
+				expected = "id,score\n4,7\n5,5\n1,3\n2,\n3,\n"
+			}
+			opts := Options{SortBy: []string{sortBy}, SortMemory: memory}
+			t.Run(name+" "+sortBy, func(t *testing.T) {
+				checkConversion(t, raw, opts, expected)
+			})
+		}
+	}
+}
+
+func TestSortConvert(t *testing.T) {
+	t.Parallel()
+
+	raw := `[
+		{"id":1, "team":"red", "score":9, "code":"9"},
+		{"id":2, "team":"blue", "score":10, "code":"10"},
+		{"id":3, "team":"red", "score":null, "code":"3"},
+		{"id":4, "team":"green", "score":10, "code":"4"},
+		{"id":5, "team":"blue", "score":2.5, "code":"25"}
+	]`
+
+	cases := []conversionCase{
+		{
+			"numeric",
+			Options{SortBy: []string{"score"}},
+			"code,id,score,team\n" +
+				"25,5,2.5,blue\n" +
+				"9,1,9,red\n" +
+				"10,2,10,blue\n" +
+				"4,4,10,green\n" +
+				"3,3,,red\n",
+			false,
+		},
+		{
+			"text",
+			Options{SortBy: []string{"code"}},
+			"code,id,score,team\n" +
+				"10,2,10,blue\n" +
+				"25,5,2.5,blue\n" +
+				"3,3,,red\n" +
+				"4,4,10,green\n" +
+				"9,1,9,red\n",
+			false,
+		},
+		{
+			"descending",
+			Options{SortBy: []string{"-score"}},
+			"code,id,score,team\n" +
+				"10,2,10,blue\n" +
+				"4,4,10,green\n" +
+				"9,1,9,red\n" +
+				"25,5,2.5,blue\n" +
+				"3,3,,red\n",
+			false,
+		},
+		{
+			"descending spilled",
+			Options{SortBy: []string{"-score"}, SortMemory: 1},
+			"code,id,score,team\n" +
+				"10,2,10,blue\n" +
+				"4,4,10,green\n" +
+				"9,1,9,red\n" +
+				"25,5,2.5,blue\n" +
+				"3,3,,red\n",
+			false,
+		},
+		{
+			"descending limited",
+			Options{SortBy: []string{"-score"}, Limit: 2},
+			"code,id,score,team\n" +
+				"10,2,10,blue\n" +
+				"4,4,10,green\n",
+			false,
+		},
+		{
+			"several columns",
+			Options{SortBy: []string{"team", "-id"}},
+			"code,id,score,team\n" +
+				"25,5,2.5,blue\n" +
+				"10,2,10,blue\n" +
+				"4,4,10,green\n" +
+				"3,3,,red\n" +
+				"9,1,9,red\n",
+			false,
+		},
+		{
+			"spilled",
+			Options{SortBy: []string{"team", "-id"}, SortMemory: 2},
+			"code,id,score,team\n" +
+				"25,5,2.5,blue\n" +
+				"10,2,10,blue\n" +
+				"4,4,10,green\n" +
+				"3,3,,red\n" +
+				"9,1,9,red\n",
+			false,
+		},
+		{
+			"spilled stable",
+			Options{SortBy: []string{"team"}, SortMemory: 1},
+			"code,id,score,team\n" +
+				"10,2,10,blue\n" +
+				"25,5,2.5,blue\n" +
+				"4,4,10,green\n" +
+				"9,1,9,red\n" +
+				"3,3,,red\n",
+			false,
+		},
+		{
+			"filtered",
+			Options{SortBy: []string{"-code"}, Filter: "team != \"red\""},
+			"code,id,score,team\n" +
+				"4,4,10,green\n" +
+				"25,5,2.5,blue\n" +
+				"10,2,10,blue\n",
+			false,
+		},
+		{"unknown column", Options{SortBy: []string{"name"}}, "", true},
+		{"malformed column", Options{SortBy: []string{"-"}}, "", true},
+	}
+	checkConversions(t, raw, cases)
+}
+
+func TestSortMerge(t *testing.T) {
+	t.Parallel()
+
+	// Nine runs of one record, merged two at a time
+	s, err := newSorter(Options{SortBy: []string{"k"}, SortMemory: 1})
+	if err != nil {
+		t.Fatalf("failed to create sorter: %s", err.Error())
+	}
+	s.width = 2
+	if err := s.prepare([]Column{{Name: "k", Type: IntegerColumn}}); err != nil {
+		t.Fatalf("failed to prepare sorter: %s", err.Error())
+	}
+	defer s.close()
+	for i, k := range []int{3, 1, 2, 1, 3, 2, 1, 2, 3} {
+		if err := s.add(map[string]interface{}{"id": float64(i + 1), "k": float64(k)}); err != nil {
+			t.Fatalf("failed to add record: %s", err.Error())
+		}
+	}
+	if len(s.runs) != 9 {
+		t.Fatalf("expected 9 runs, found %d", len(s.runs))
+	}
+
+	ids := []string{}
+	err = s.flush(func(record map[string]interface{}) error {
+		ids = append(ids, toString(record["id"]))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to merge runs: %s", err.Error())
+	}
+	if found := strings.Join(ids, ","); found != "2,4,7,3,6,8,1,5,9" {
+		t.Errorf("expected records 2,4,7,3,6,8,1,5,9, found %s", found)
+	}
+	if len(s.runs) > 2 {
+		t.Errorf("expected at most 2 runs left, found %d", len(s.runs))
+	}
+
+	names := []string{}
+	for _, run := range s.runs {
+		names = append(names, run.Name())
+	}
+	s.close()
+	for _, name := range names {
+		if _, err := os.Stat(name); os.IsNotExist(err) == false {
+			t.Errorf("expected run %s to be removed", name)
+		}
+	}
+}
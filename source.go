@@ -0,0 +1,355 @@
+package fjson2csv
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// Text encodings of binary values, for input formats which have them.
+const (
+	Base64Encoding string = "base64"
+	HexEncoding    string = "hex"
+)
+
+// A record read from input, as its properties in the order they appear.
+type Record []Field
+
+// A single property of a record.
+//
+// Values have the types produced by encoding/json: float64, string, bool,
+// nil, []interface{} and map[string]interface{}.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Returns the properties of a record as a map.
+func (r Record) Map() map[string]interface{} {
+	m := make(map[string]interface{}, len(r))
+	for _, field := range r {
+		m[field.Key] = field.Value
+	}
+	return m
+}
+
+// Yields the records of an input format.
+//
+// Conversions read all records from a source to index their fields, then
+// reset it and read them again to write them (unless converting in-memory).
+type RecordSource interface {
+	// Returns the next record, or `io.EOF` once all records have been read.
+	Next() (Record, error)
+	// Rewinds the source, so its records can be read again from the start.
+	Reset() error
+}
+
+// Creates the record source for the input format given in the options.
+func newRecordSource(r io.ReadSeeker, opts Options) (RecordSource, error) {
+	if opts.Source != nil {
+		return opts.Source, nil
+	}
+	switch opts.InputFormat {
+	case "", JsonFormat:
+		return NewJsonSource(r, opts), nil
+	case NdjsonFormat, MsgpackFormat, CborFormat, YamlFormat:
+		if opts.Path != "" || opts.KeyColumn != "" {
+			return nil, fmt.Errorf("paths and key columns are not supported by %s input", opts.InputFormat)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported input format: %s", opts.InputFormat)
+	}
+	switch opts.BinaryEncoding {
+	case "", Base64Encoding, HexEncoding:
+	default:
+		return nil, fmt.Errorf("unsupported binary encoding: %s", opts.BinaryEncoding)
+	}
+
+	switch opts.InputFormat {
+	case MsgpackFormat:
+		return NewMsgpackSource(r, opts), nil
+	case CborFormat:
+		return NewCborSource(r, opts), nil
+	case YamlFormat:
+		return NewYamlSource(r, opts), nil
+	}
+	return NewNdjsonSource(r, opts), nil
+}
+
+// Reads records from a JSON array of objects (or, given `Options.KeyColumn`,
+// an object whose members are records), found at `Options.Path`.
+type jsonSource struct {
+	r         io.ReadSeeker
+	dec       *json.Decoder
+	path      []string
+	keyColumn string
+	size      int
+	done      bool
+}
+
+// Creates a source reading records from a JSON document.
+func NewJsonSource(r io.ReadSeeker, opts Options) RecordSource {
+	rsize, _ := getBufferSizes(opts)
+	return &jsonSource{
+		r:         r,
+		path:      parsePath(opts.Path),
+		keyColumn: opts.KeyColumn,
+		size:      rsize,
+	}
+}
+
+func (s *jsonSource) Next() (Record, error) {
+	if s.done {
+		return nil, io.EOF
+	}
+	collection := "array"
+	if s.keyColumn != "" {
+		collection = "object"
+	}
+	if s.dec == nil {
+		if err := s.open(collection); err != nil {
+			return nil, err
+		}
+	}
+
+	// Closing bracket
+	if s.dec.More() == false {
+		if _, err := s.dec.Token(); err != nil {
+			return nil, fmt.Errorf("malformed JSON: %s does not end properly", collection)
+		}
+		s.done = true
+		return nil, io.EOF
+	}
+
+	var id string
+	if s.keyColumn != "" {
+		if token, err := s.dec.Token(); err != nil {
+			return nil, err
+		} else {
+			id = token.(string)
+		}
+	}
+
+	record, err := decodeRecord(s.dec)
+	if err == errNotObject {
+		return nil, fmt.Errorf("malformed JSON: document must be an %s of objects", collection)
+	} else if err != nil {
+		return nil, err
+	}
+	if s.keyColumn != "" {
+		for _, field := range record {
+			if field.Key == s.keyColumn {
+				return nil, fmt.Errorf("key column '%s' conflicts with a property of record '%s'", s.keyColumn, id)
+			}
+		}
+		record = append(Record{{s.keyColumn, id}}, record...)
+	}
+	return record, nil
+}
+
+// Locates the collection of records and reads its opening bracket (or
+// brace, for records keyed by ID).
+func (s *jsonSource) open(collection string) error {
+	s.dec = json.NewDecoder(bufio.NewReaderSize(s.r, s.size))
+	if err := seekPath(s.dec, s.path); err != nil {
+		return err
+	}
+
+	opening := "["
+	if s.keyColumn != "" {
+		opening = "{"
+	}
+	token, err := s.dec.Token()
+	if err != nil {
+		return fmt.Errorf("malformed JSON")
+	}
+	delim, ok := token.(json.Delim)
+	if ok == false || delim.String() != opening {
+		return fmt.Errorf("malformed JSON: document must be an %s of objects", collection)
+	}
+	return nil
+}
+
+func (s *jsonSource) Reset() error {
+	if _, err := s.r.Seek(0, 0); err != nil {
+		return fmt.Errorf("file read failure: %s", err.Error())
+	}
+	s.dec = nil
+	s.done = false
+	return nil
+}
+
+// Reads records from newline delimited JSON, with one object per line.
+type ndjsonSource struct {
+	r    io.ReadSeeker
+	dec  *json.Decoder
+	size int
+}
+
+// Creates a source reading records from newline delimited JSON.
+func NewNdjsonSource(r io.ReadSeeker, opts Options) RecordSource {
+	rsize, _ := getBufferSizes(opts)
+	return &ndjsonSource{r: r, size: rsize}
+}
+
+func (s *ndjsonSource) Next() (Record, error) {
+	if s.dec == nil {
+		s.dec = json.NewDecoder(bufio.NewReaderSize(s.r, s.size))
+	}
+	record, err := decodeRecord(s.dec)
+	if err == errNotObject {
+		return nil, fmt.Errorf("malformed NDJSON: each line must be an object")
+	}
+	return record, err
+}
+
+func (s *ndjsonSource) Reset() error {
+	if _, err := s.r.Seek(0, 0); err != nil {
+		return fmt.Errorf("file read failure: %s", err.Error())
+	}
+	s.dec = nil
+	return nil
+}
+
+var errNotObject = errors.New("not an object")
+
+// Decodes the next value from a decoder as a record, keeping the order of its
+// properties. Returns `io.EOF` when there is no value left to decode and
+// `errNotObject` when the value is not an object.
+func decodeRecord(dec *json.Decoder) (Record, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if delim, ok := token.(json.Delim); ok == false || delim.String() != "{" {
+		return nil, errNotObject
+	}
+
+	record := Record{}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("malformed JSON: %s", err.Error())
+		}
+		var value interface{}
+		if err := dec.Decode(&value); err != nil {
+			return nil, fmt.Errorf("malformed JSON: %s", err.Error())
+		}
+		record = append(record, Field{key.(string), value})
+	}
+
+	// Closing brace
+	if _, err := dec.Token(); err != nil {
+		return nil, fmt.Errorf("malformed JSON: object does not end properly")
+	}
+	return record, nil
+}
+
+// Decodes records from a binary input format.
+type binaryDecoder interface {
+	// Reads the header of an array of records, if one is next. Returns the
+	// length of the array, or -1 when it ends with a break marker.
+	readArray() (n int, ok bool, err error)
+	// Reads the break marker ending an array, if one is next.
+	readBreak() (bool, error)
+	// Reads the next record.
+	readRecord() (Record, error)
+}
+
+// Reads records from a binary input format, encoded either as a single array
+// of records or as a sequence of records.
+type binarySource struct {
+	r          io.ReadSeeker
+	br         *bufio.Reader
+	dec        binaryDecoder
+	newDecoder func(r *bufio.Reader) binaryDecoder
+	size       int
+	array      bool
+	remaining  int
+}
+
+func (s *binarySource) Next() (Record, error) {
+	if s.dec == nil {
+		s.br = bufio.NewReaderSize(s.r, s.size)
+		s.dec = s.newDecoder(s.br)
+		if _, err := s.br.Peek(1); err != io.EOF {
+			n, ok, err := s.dec.readArray()
+			if err != nil {
+				return nil, err
+			}
+			s.array, s.remaining = ok, n
+		}
+	}
+
+	switch {
+	case s.array == false:
+		if _, err := s.br.Peek(1); err == io.EOF {
+			return nil, io.EOF
+		}
+	case s.remaining == 0:
+		return nil, io.EOF
+	case s.remaining > 0:
+		s.remaining--
+	default:
+		// Arrays of unknown length end with a break marker
+		if end, err := s.dec.readBreak(); err != nil {
+			return nil, err
+		} else if end {
+			s.remaining = 0
+			return nil, io.EOF
+		}
+	}
+	return s.dec.readRecord()
+}
+
+func (s *binarySource) Reset() error {
+	if _, err := s.r.Seek(0, 0); err != nil {
+		return fmt.Errorf("file read failure: %s", err.Error())
+	}
+	s.dec = nil
+	s.array = false
+	return nil
+}
+
+// Encodes a binary value as text.
+func encodeBinary(data []byte, encoding string) string {
+	if encoding == HexEncoding {
+		return hex.EncodeToString(data)
+	}
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+// Converts a map key of a binary input format, which need not be a string,
+// into a property name.
+func mapKey(key interface{}) string {
+	switch k := key.(type) {
+	case string:
+		return k
+	case float64:
+		return strconv.FormatFloat(k, 'f', -1, 64)
+	}
+	return fmt.Sprint(key)
+}
+
+// Reads exactly n bytes. Memory grows with the data actually read, so a
+// malformed length cannot force a large allocation.
+func readBytes(r io.Reader, n uint64) ([]byte, error) {
+	if n > math.MaxInt64 {
+		return nil, fmt.Errorf("invalid length %d", n)
+	}
+	buffer := bytes.Buffer{}
+	if _, err := io.CopyN(&buffer, r, int64(n)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
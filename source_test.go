@@ -0,0 +1,180 @@
+package fjson2csv
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// Reads all records from a source, formatted as "key=value" pairs.
+func readSource(source RecordSource) ([]string, error) {
+	found := []string{}
+	for {
+		record, err := source.Next()
+		if err == io.EOF {
+			return found, nil
+		} else if err != nil {
+			return found, err
+		}
+		pairs := make([]string, len(record))
+		for i, field := range record {
+			pairs[i] = fmt.Sprintf("%s=%v", field.Key, field.Value)
+		}
+		found = append(found, strings.Join(pairs, " "))
+	}
+}
+
+func TestJsonSource(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		raw      string
+		opts     Options
+		expected []string
+		willFail bool
+	}{
+		{"ordered", `[{"b":1, "a":"x"}, {"c":true, "b":null}]`, Options{}, []string{"b=1 a=x", "c=true b=<nil>"}, false},
+		{"empty", `[]`, Options{}, []string{}, false},
+		{"path", `{"data":{"items":[{"a":1}]}}`, Options{Path: "data.items"}, []string{"a=1"}, false},
+		{"keyed", `{"u1":{"name":"a"}, "u2":{}}`, Options{KeyColumn: "id"}, []string{"id=u1 name=a", "id=u2"}, false},
+		{"non-object record", `[{"a":1}, 2]`, Options{}, []string{"a=1"}, true},
+		{"unterminated", `[{"a":1}`, Options{}, []string{"a=1"}, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			source := NewJsonSource(strings.NewReader(tc.raw), tc.opts)
+
+			// Records must be the same after a reset
+			for pass := 1; pass <= 2; pass++ {
+				found, err := readSource(source)
+				if (err != nil) != tc.willFail {
+					t.Fatalf("expected failure: %t, found: %v", tc.willFail, err)
+				}
+				if strings.Join(found, "\n") != strings.Join(tc.expected, "\n") {
+					t.Logf("pass %d did not match expected records", pass)
+					t.Logf("Expected:\n%s", strings.Join(tc.expected, "\n"))
+					t.Logf("Found:\n%s", strings.Join(found, "\n"))
+					t.FailNow()
+				}
+				if err := source.Reset(); err != nil {
+					t.Fatalf("failed to reset source: %s", err.Error())
+				}
+			}
+		})
+	}
+}
+
+func TestNdjsonSource(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		raw      string
+		expected []string
+		willFail bool
+	}{
+		{"records", "{\"b\":1, \"a\":\"x\"}\n\n{\"c\":[1,2]}\n", []string{"b=1 a=x", "c=[1 2]"}, false},
+		{"no trailing newline", `{"a":1}`, []string{"a=1"}, false},
+		{"empty", "", []string{}, false},
+		{"non-object record", "{\"a\":1}\n[1]\n", []string{"a=1"}, true},
+		{"truncated record", "{\"a\":1}\n{\"b\":", []string{"a=1"}, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			source := NewNdjsonSource(strings.NewReader(tc.raw), Options{})
+			for pass := 1; pass <= 2; pass++ {
+				found, err := readSource(source)
+				if (err != nil) != tc.willFail {
+					t.Fatalf("expected failure: %t, found: %v", tc.willFail, err)
+				}
+				if strings.Join(found, "\n") != strings.Join(tc.expected, "\n") {
+					t.Logf("pass %d did not match expected records", pass)
+					t.Logf("Expected:\n%s", strings.Join(tc.expected, "\n"))
+					t.Logf("Found:\n%s", strings.Join(found, "\n"))
+					t.FailNow()
+				}
+				if err := source.Reset(); err != nil {
+					t.Fatalf("failed to reset source: %s", err.Error())
+				}
+			}
+		})
+	}
+}
+
+// Yields records from a slice.
+type sliceSource struct {
+	records []Record
+	next    int
+	resets  int
+}
+
+func (s *sliceSource) Next() (Record, error) {
+	if s.next >= len(s.records) {
+		return nil, io.EOF
+	}
+	s.next++
+	return s.records[s.next-1], nil
+}
+
+func (s *sliceSource) Reset() error {
+	s.next = 0
+	s.resets++
+	return nil
+}
+
+func TestRecordSourceConvert(t *testing.T) {
+	t.Parallel()
+
+	expected := "name,age\nJane,31\nJohn,\n"
+	for name, convert := range conversions {
+		sources := map[string]Options{
+			"ndjson": {InputFormat: NdjsonFormat},
+			"custom": {Source: &sliceSource{records: []Record{
+				{{"name", "Jane"}, {"age", float64(31)}},
+				{{"name", "John"}},
+			}}},
+		}
+		for source, opts := range sources {
+			raw := "{\"name\":\"Jane\",\"age\":31}\n{\"name\":\"John\"}\n"
+			buffer := bytes.Buffer{}
+			if err := convert(strings.NewReader(raw), &buffer, opts); err != nil {
+				t.Fatalf("%s %s conversion failure: %s", name, source, err.Error())
+			}
+			checkOutput(t, name+" "+source+" conversion", expected, buffer.String())
+		}
+	}
+}
+
+func TestRecordSourceOptions(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		opts     Options
+		willFail bool
+	}{
+		{"default", Options{}, false},
+		{"json", Options{InputFormat: JsonFormat, Path: "data", KeyColumn: "id"}, false},
+		{"ndjson", Options{InputFormat: NdjsonFormat}, false},
+		{"ndjson path", Options{InputFormat: NdjsonFormat, Path: "data"}, true},
+		{"ndjson key column", Options{InputFormat: NdjsonFormat, KeyColumn: "id"}, true},
+		{"msgpack hex", Options{InputFormat: MsgpackFormat, BinaryEncoding: HexEncoding}, false},
+		{"cbor base64", Options{InputFormat: CborFormat, BinaryEncoding: Base64Encoding}, false},
+		{"cbor path", Options{InputFormat: CborFormat, Path: "data"}, true},
+		{"yaml", Options{InputFormat: YamlFormat}, false},
+		{"yaml key column", Options{InputFormat: YamlFormat, KeyColumn: "id"}, true},
+		{"unknown binary encoding", Options{InputFormat: MsgpackFormat, BinaryEncoding: "base32"}, true},
+		{"unknown format", Options{InputFormat: "toml"}, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := newRecordSource(strings.NewReader(""), tc.opts)
+			if (err != nil) != tc.willFail {
+				t.Errorf("expected failure: %t, found: %v", tc.willFail, err)
+			}
+		})
+	}
+}
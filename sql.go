@@ -0,0 +1,207 @@
+package fjson2csv
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// SQL dialects supported by the SQL script output format.
+const (
+	PostgresDialect string = "postgres"
+	MysqlDialect    string = "mysql"
+)
+
+// Writes records as an SQL script, which creates a table and fills it with
+// either batched multi-row INSERT statements or (for Postgres) a single
+// `COPY ... FROM stdin` block.
+type sqlEncoder struct {
+	ew      *errWriter
+	dialect string
+	copy    bool
+	table   string
+	columns []Column
+	batch   int
+	pending int
+}
+
+func newSqlEncoder(w io.Writer, size int, opts Options) (*sqlEncoder, error) {
+	s := &sqlEncoder{
+		ew:      newErrorWriter(w, size),
+		dialect: opts.Dialect,
+		copy:    opts.Copy,
+		table:   opts.Table,
+		batch:   opts.BatchSize,
+	}
+	if s.dialect == "" {
+		s.dialect = PostgresDialect
+	}
+	if s.dialect != PostgresDialect && s.dialect != MysqlDialect {
+		return nil, fmt.Errorf("unsupported SQL dialect: %s", s.dialect)
+	}
+	if s.copy && s.dialect != PostgresDialect {
+		return nil, fmt.Errorf("COPY is only supported by the %s dialect", PostgresDialect)
+	}
+	if s.table == "" {
+		s.table = default_table
+	}
+	if s.batch < 1 {
+		s.batch = default_batch_size
+	}
+	return s, nil
+}
+
+func (s *sqlEncoder) WriteHeader(columns []Column) error {
+	s.columns = columns
+	if len(columns) == 0 {
+		return nil
+	}
+
+	definitions := make([]string, len(columns))
+	for i, col := range columns {
+		definitions[i] = "  " + s.quoteIdentifier(col.Name) + " " + s.columnType(col.Type)
+		if col.Nullable == false {
+			definitions[i] += " NOT NULL"
+		}
+	}
+	s.ew.write(fmt.Sprintf("CREATE TABLE %s (\n%s\n);\n\n", s.quoteIdentifier(s.table), strings.Join(definitions, ",\n")))
+	if s.copy {
+		s.ew.write(fmt.Sprintf("COPY %s (%s) FROM stdin;\n", s.quoteIdentifier(s.table), s.columnList()))
+	}
+	return s.ew.err
+}
+
+func (s *sqlEncoder) WriteRow(record map[string]interface{}) error {
+	if len(s.columns) == 0 {
+		return nil
+	}
+	if s.copy {
+		for i, col := range s.columns {
+			if i > 0 {
+				s.ew.write("\t")
+			}
+			s.ew.write(copyValue(col.Convert(record[col.Name])))
+		}
+		s.ew.write("\n")
+		return s.ew.err
+	}
+
+	if s.pending == 0 {
+		s.ew.write(fmt.Sprintf("INSERT INTO %s (%s) VALUES\n", s.quoteIdentifier(s.table), s.columnList()))
+	} else {
+		s.ew.write(",\n")
+	}
+	values := make([]string, len(s.columns))
+	for i, col := range s.columns {
+		values[i] = s.literal(col.Convert(record[col.Name]))
+	}
+	s.ew.write("(" + strings.Join(values, ", ") + ")")
+	s.pending++
+	if s.pending >= s.batch {
+		s.ew.write(";\n")
+		s.pending = 0
+	}
+	return s.ew.err
+}
+
+func (s *sqlEncoder) Close() error {
+	if s.copy && len(s.columns) > 0 {
+		s.ew.write("\\.\n")
+	}
+	if s.pending > 0 {
+		s.ew.write(";\n")
+	}
+	s.ew.flush()
+	return s.ew.err
+}
+
+func (s *sqlEncoder) columnList() string {
+	names := make([]string, len(s.columns))
+	for i, col := range s.columns {
+		names[i] = s.quoteIdentifier(col.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+func (s *sqlEncoder) columnType(kind ColumnType) string {
+	switch kind {
+	case IntegerColumn:
+		return "BIGINT"
+	case FloatColumn:
+		if s.dialect == MysqlDialect {
+			return "DOUBLE"
+		}
+		return "DOUBLE PRECISION"
+	case BooleanColumn:
+		return "BOOLEAN"
+	default:
+		return "TEXT"
+	}
+}
+
+// Quotes an identifier, such as a table or column name.
+func (s *sqlEncoder) quoteIdentifier(name string) string {
+	if s.dialect == MysqlDialect {
+		return "`" + strings.Replace(name, "`", "``", -1) + "`"
+	}
+	return quoteIdentifier(name)
+}
+
+// Formats a column value as an SQL literal.
+func (s *sqlEncoder) literal(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		if v {
+			return "TRUE"
+		}
+		return "FALSE"
+	}
+
+	str := value.(string)
+	if s.dialect == MysqlDialect {
+		// MySQL treats backslashes in string literals as escapes
+		return "'" + mysqlEscaper.Replace(str) + "'"
+	}
+	return "'" + strings.Replace(str, "'", "''", -1) + "'"
+}
+
+var mysqlEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`'`, `\'`,
+	"\x00", `\0`,
+	"\n", `\n`,
+	"\r", `\r`,
+	"\x1a", `\Z`,
+)
+
+// Formats a column value for the text format of Postgres' COPY.
+func copyValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return `\N`
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		if v {
+			return "t"
+		}
+		return "f"
+	}
+	return copyEscaper.Replace(value.(string))
+}
+
+var copyEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"\t", `\t`,
+	"\n", `\n`,
+	"\r", `\r`,
+)
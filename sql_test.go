@@ -0,0 +1,109 @@
+package fjson2csv
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSqlEncoder(t *testing.T) {
+	t.Parallel()
+
+	raw := `[
+		{"id":1, "name":"it's", "ok":true, "ratio":0.5},
+		{"id":2, "name":"back\\slash\ttab", "ratio":1},
+		{"id":3, "ok":false, "ratio":2.25}
+	]`
+
+	cases := []struct {
+		name     string
+		opts     Options
+		expected string
+	}{
+		{
+			"postgres insert",
+			Options{Format: SqlFormat, BatchSize: 2},
+			`CREATE TABLE "records" (
+  "id" BIGINT NOT NULL,
+  "ratio" DOUBLE PRECISION NOT NULL,
+  "name" TEXT,
+  "ok" BOOLEAN
+);
+
+INSERT INTO "records" ("id", "ratio", "name", "ok") VALUES
+(1, 0.5, 'it''s', TRUE),
+(2, 1, 'back\slash	tab', NULL);
+INSERT INTO "records" ("id", "ratio", "name", "ok") VALUES
+(3, 2.25, NULL, FALSE);
+`,
+		},
+		{
+			"postgres copy",
+			Options{Format: SqlFormat, Copy: true, Table: "people"},
+			`CREATE TABLE "people" (
+  "id" BIGINT NOT NULL,
+  "ratio" DOUBLE PRECISION NOT NULL,
+  "name" TEXT,
+  "ok" BOOLEAN
+);
+
+COPY "people" ("id", "ratio", "name", "ok") FROM stdin;
+1	0.5	it's	t
+2	1	back\\slash\ttab	\N
+3	2.25	\N	f
+\.
+`,
+		},
+		{
+			"mysql insert",
+			Options{Format: SqlFormat, Dialect: MysqlDialect},
+			"CREATE TABLE `records` (\n" +
+				"  `id` BIGINT NOT NULL,\n" +
+				"  `ratio` DOUBLE NOT NULL,\n" +
+				"  `name` TEXT,\n" +
+				"  `ok` BOOLEAN\n" +
+				");\n\n" +
+				"INSERT INTO `records` (`id`, `ratio`, `name`, `ok`) VALUES\n" +
+				"(1, 0.5, 'it\\'s', TRUE),\n" +
+				"(2, 1, 'back\\\\slash\ttab', NULL),\n" +
+				"(3, 2.25, NULL, FALSE);\n",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			buffer := bytes.Buffer{}
+			if err := UnbufferedConvert(strings.NewReader(raw), &buffer, tc.opts); err != nil {
+				t.Fatalf("conversion failure: %s", err.Error())
+			}
+			if buffer.String() != tc.expected {
+				t.Logf("SQL output did not match")
+				t.Logf("Expected:\n%s", tc.expected)
+				t.Logf("Found:\n%s", buffer.String())
+				t.FailNow()
+			}
+		})
+	}
+}
+
+func TestSqlEncoderOptions(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		opts     Options
+		willFail bool
+	}{
+		{"default", Options{}, false},
+		{"mysql", Options{Dialect: MysqlDialect}, false},
+		{"unknown dialect", Options{Dialect: "oracle"}, true},
+		{"mysql copy", Options{Dialect: MysqlDialect, Copy: true}, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := newSqlEncoder(&bytes.Buffer{}, default_write_buffer_size, tc.opts)
+			if (err != nil) != tc.willFail {
+				t.Errorf("expected failure: %t, found: %v", tc.willFail, err)
+			}
+		})
+	}
+}
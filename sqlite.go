@@ -0,0 +1,169 @@
+package fjson2csv
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const default_table string = "records"
+const default_batch_size int = 1000
+
+// Name of the database/sql driver used to write SQLite output. No driver is
+// imported by this package; programs must register one (eg. by importing
+// the pure-Go "modernc.org/sqlite"), or SQLite output fails.
+const sqlite_driver string = "sqlite"
+
+// Writes records into a table of a new SQLite database.
+//
+// SQLite databases are files, so the database is built in a temporary file
+// and copied to the destination once all records have been inserted. Records
+// are inserted in batches, each within its own transaction, which prepares
+// the insert statement once.
+type sqliteEncoder struct {
+	w       io.Writer
+	driver  string
+	path    string
+	db      *sql.DB
+	tx      *sql.Tx
+	query   string
+	insert  *sql.Stmt
+	table   string
+	columns []Column
+	batch   int
+	pending int
+	err     error
+}
+
+func newSqliteEncoder(w io.Writer, table string, batch int) *sqliteEncoder {
+	if table == "" {
+		table = default_table
+	}
+	if batch < 1 {
+		batch = default_batch_size
+	}
+	return &sqliteEncoder{
+		w:      w,
+		driver: sqlite_driver,
+		table:  table,
+		batch:  batch,
+	}
+}
+
+func (s *sqliteEncoder) WriteHeader(columns []Column) error {
+	s.columns = columns
+
+	registered := false
+	for _, name := range sql.Drivers() {
+		registered = registered || name == s.driver
+	}
+	if registered == false {
+		s.err = fmt.Errorf("unsupported output format: %s (no '%s' database/sql driver is registered)", SqliteFormat, s.driver)
+		return s.err
+	}
+
+	file, err := os.CreateTemp("", "fjson2csv-*.sqlite")
+	if err != nil {
+		s.err = fmt.Errorf("failed to create database: %s", err.Error())
+		return s.err
+	}
+	s.path = file.Name()
+	file.Close()
+
+	if s.db, s.err = sql.Open(s.driver, s.path); s.err != nil {
+		return s.err
+	}
+	if len(columns) == 0 {
+		return nil
+	}
+
+	definitions := make([]string, len(columns))
+	placeholders := make([]string, len(columns))
+	for i, col := range columns {
+		definitions[i] = quoteIdentifier(col.Name) + " " + sqliteType(col.Type)
+		if col.Nullable == false {
+			definitions[i] += " NOT NULL"
+		}
+		placeholders[i] = "?"
+	}
+	create := fmt.Sprintf("CREATE TABLE %s (%s)", quoteIdentifier(s.table), strings.Join(definitions, ", "))
+	if _, s.err = s.db.Exec(create); s.err != nil {
+		return s.err
+	}
+	s.query = fmt.Sprintf("INSERT INTO %s VALUES (%s)", quoteIdentifier(s.table), strings.Join(placeholders, ", "))
+	return s.begin()
+}
+
+func (s *sqliteEncoder) WriteRow(record map[string]interface{}) error {
+	if s.err != nil || s.insert == nil {
+		return s.err
+	}
+	values := make([]interface{}, len(s.columns))
+	for i, col := range s.columns {
+		values[i] = col.Convert(record[col.Name])
+	}
+	if _, s.err = s.insert.Exec(values...); s.err != nil {
+		return s.err
+	}
+	s.pending++
+	if s.pending >= s.batch {
+		if s.err = s.tx.Commit(); s.err == nil {
+			s.err = s.begin()
+		}
+	}
+	return s.err
+}
+
+func (s *sqliteEncoder) Close() error {
+	if s.tx != nil && s.err == nil {
+		s.err = s.tx.Commit()
+	}
+	if s.db != nil {
+		if err := s.db.Close(); s.err == nil {
+			s.err = err
+		}
+	}
+	if s.path == "" {
+		return s.err
+	}
+	defer os.Remove(s.path)
+
+	// Copy the finished database to the destination
+	if s.err == nil {
+		var file *os.File
+		if file, s.err = os.Open(s.path); s.err == nil {
+			_, s.err = io.Copy(s.w, file)
+			file.Close()
+		}
+	}
+	return s.err
+}
+
+// Starts the transaction for the next batch of inserts, and prepares its
+// insert statement (which is closed along with the transaction).
+func (s *sqliteEncoder) begin() error {
+	s.pending = 0
+	if s.tx, s.err = s.db.Begin(); s.err != nil {
+		return s.err
+	}
+	s.insert, s.err = s.tx.Prepare(s.query)
+	return s.err
+}
+
+func sqliteType(kind ColumnType) string {
+	switch kind {
+	case IntegerColumn, BooleanColumn:
+		return "INTEGER"
+	case FloatColumn:
+		return "REAL"
+	default:
+		return "TEXT"
+	}
+}
+
+// Quotes an SQL identifier, such as a table or column name.
+func quoteIdentifier(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
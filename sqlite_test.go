@@ -0,0 +1,150 @@
+package fjson2csv
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+/*
+ * A database/sql driver which records the statements executed against it,
+ * standing in for a real SQLite driver.
+ */
+
+type recorder struct {
+	sync.Mutex
+	log []string
+}
+
+func (r *recorder) Open(name string) (driver.Conn, error) { return recorderConn{r}, nil }
+
+func (r *recorder) record(entry string) {
+	r.Lock()
+	defer r.Unlock()
+	r.log = append(r.log, entry)
+}
+
+type recorderConn struct{ r *recorder }
+
+func (c recorderConn) Prepare(query string) (driver.Stmt, error) {
+	c.r.record("PREPARE " + query)
+	return recorderStmt{c.r, query}, nil
+}
+func (c recorderConn) Close() error { return nil }
+func (c recorderConn) Begin() (driver.Tx, error) {
+	c.r.record("BEGIN")
+	return recorderTx{c.r}, nil
+}
+
+type recorderTx struct{ r *recorder }
+
+func (tx recorderTx) Commit() error   { tx.r.record("COMMIT"); return nil }
+func (tx recorderTx) Rollback() error { tx.r.record("ROLLBACK"); return nil }
+
+type recorderStmt struct {
+	r     *recorder
+	query string
+}
+
+func (s recorderStmt) Close() error  { return nil }
+func (s recorderStmt) NumInput() int { return -1 }
+func (s recorderStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.r.record(fmt.Sprintf("%s %v", s.query, args))
+	return driver.RowsAffected(1), nil
+}
+func (s recorderStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, fmt.Errorf("not supported")
+}
+
+var sqliteRecorder = &recorder{}
+
+func init() {
+	sql.Register("fjson2csv-recorder", sqliteRecorder)
+}
+
+func TestSqliteEncoder(t *testing.T) {
+	sqliteRecorder.Lock()
+	sqliteRecorder.log = nil
+	sqliteRecorder.Unlock()
+
+	c := converter{Keys: map[string]int64{}}
+	records := []map[string]interface{}{
+		{"id": float64(1), "name": "Jane", "ok": true, "ratio": 0.5},
+		{"id": float64(2), "name": `O"Brien`, "ratio": float64(1)},
+		{"id": float64(3), "ok": false, "ratio": 2.25},
+	}
+	for _, record := range records {
+		extractKeys(record, &c)
+	}
+	c.sorted = []string{"id", "ratio", "name", "ok"}
+
+	buffer := bytes.Buffer{}
+	s := newSqliteEncoder(&buffer, `my "table"`, 2)
+	s.driver = "fjson2csv-recorder"
+	if err := s.WriteHeader(c.columns()); err != nil {
+		t.Fatalf("failed to create table: %s", err.Error())
+	}
+	for _, record := range records {
+		if err := s.WriteRow(record); err != nil {
+			t.Fatalf("failed to insert record: %s", err.Error())
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("failed to finish database: %s", err.Error())
+	}
+
+	insert := `INSERT INTO "my ""table""" VALUES (?, ?, ?, ?)`
+	create := `CREATE TABLE "my ""table""" ("id" INTEGER NOT NULL, "ratio" REAL NOT NULL, "name" TEXT, "ok" INTEGER)`
+	expected := []string{
+		"PREPARE " + create,
+		create + " []",
+		"BEGIN",
+		"PREPARE " + insert,
+		insert + " [1 0.5 Jane true]",
+		insert + ` [2 1 O"Brien <nil>]`,
+		"COMMIT",
+		"BEGIN",
+		"PREPARE " + insert,
+		insert + " [3 2.25 <nil> false]",
+		"COMMIT",
+	}
+	if strings.Join(sqliteRecorder.log, "\n") != strings.Join(expected, "\n") {
+		t.Logf("unexpected statements")
+		t.Logf("Expected:\n%s", strings.Join(expected, "\n"))
+		t.Logf("Found:\n%s", strings.Join(sqliteRecorder.log, "\n"))
+		t.FailNow()
+	}
+}
+
+func TestSqliteDriverMissing(t *testing.T) {
+	t.Parallel()
+
+	s := newSqliteEncoder(&bytes.Buffer{}, "", 0)
+	s.driver = "fjson2csv-unregistered"
+	err := s.WriteHeader([]Column{{Name: "id", Type: IntegerColumn}})
+	if err == nil || strings.Contains(err.Error(), "fjson2csv-unregistered") == false {
+		t.Fatalf("expected missing driver failure, found %v", err)
+	}
+	if err := s.Close(); err == nil {
+		t.Errorf("expected failure on close")
+	}
+}
+
+func TestQuoteIdentifier(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]string{
+		"name":       `"name"`,
+		"first name": `"first name"`,
+		`say "hi"`:   `"say ""hi"""`,
+	}
+	for name, expected := range cases {
+		if quoted := quoteIdentifier(name); quoted != expected {
+			t.Errorf("expected %s, found %s", expected, quoted)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package fjson2csv
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Units of numeric timestamps in epoch columns (see `Options.EpochColumns`).
+const (
+	EpochSeconds      string = "s"
+	EpochMilliseconds string = "ms"
+	// Reads timestamps as milliseconds when they are too large to be seconds
+	// (ie. beyond the year 5000), and as seconds otherwise
+	EpochAuto string = "auto"
+)
+
+// Smallest epoch timestamp read as milliseconds by `EpochAuto`.
+const epoch_auto_threshold float64 = 1e11
+
+// Layouts of string values recognized as timestamps or dates. Timestamps
+// without a time zone are read as UTC.
+var time_layouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+// Parses a string in any of the recognized timestamp layouts.
+func parseTimestamp(value string) (time.Time, bool) {
+	// Every layout starts with a date (eg. "2006-01-02")
+	if len(value) < 10 || value[4] != '-' || value[7] != '-' || value[0] < '0' || value[0] > '9' {
+		return time.Time{}, false
+	}
+	for _, layout := range time_layouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Converts a numeric timestamp, given in the unit of its epoch column.
+func epochTime(n float64, unit string) time.Time {
+	whole, fraction := math.Modf(n)
+	if unit == EpochMilliseconds || (unit == EpochAuto && math.Abs(n) >= epoch_auto_threshold) {
+		// Split whole milliseconds, rather than dividing, to keep precision
+		millis := int64(whole)
+		return time.Unix(millis/1000, (millis%1000)*1e6+int64(math.Round(fraction*1e6))).UTC()
+	}
+	return time.Unix(int64(whole), int64(math.Round(fraction*1e9))).UTC()
+}
+
+// Finds the columns whose values are reformatted as timestamps: epoch
+// columns, and (when a layout or time zone is given) fields whose values were
+// all timestamps while indexing.
+func (c *converter) detectTimes() error {
+	c.times = map[string]string{}
+	for key, unit := range c.epochs {
+		switch unit {
+		case EpochSeconds, EpochMilliseconds, EpochAuto:
+		default:
+			return fmt.Errorf("unsupported epoch unit: %s", unit)
+		}
+		c.times[key] = unit
+	}
+	if c.timeLayout == "" && c.timeZone == nil {
+		return nil
+	}
+	for _, key := range c.sorted {
+		stats := c.stats(key)
+		if _, ok := c.times[key]; ok == false && stats.types&^nullType == stringType && stats.untimed == false {
+			c.times[key] = ""
+		}
+	}
+	return nil
+}
+
+// Reformats the timestamps of a record's time columns. Values which are not
+// timestamps are left as they are.
+func (c *converter) formatTimes(record map[string]interface{}) {
+	if len(c.times) == 0 {
+		return
+	}
+	layout, zone := c.timeLayout, c.timeZone
+	if layout == "" {
+		layout = time.RFC3339
+	}
+	if zone == nil {
+		zone = time.UTC
+	}
+
+	for key, unit := range c.times {
+		var t time.Time
+		switch v := record[key].(type) {
+		case float64:
+			if unit == "" {
+				continue
+			}
+			t = epochTime(v, unit)
+		case string:
+			var ok bool
+			if t, ok = parseTimestamp(v); ok == false {
+				n, err := strconv.ParseFloat(v, 64)
+				if unit == "" || err != nil {
+					continue
+				}
+				t = epochTime(n, unit)
+			}
+		default:
+			continue
+		}
+		record[key] = t.In(zone).Format(layout)
+	}
+}
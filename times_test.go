@@ -0,0 +1,109 @@
+package fjson2csv
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		value    string
+		expected string
+		ok       bool
+	}{
+		{"2024-03-01T12:00:00Z", "2024-03-01T12:00:00Z", true},
+		{"2024-03-01T07:00:00.5-05:00", "2024-03-01T12:00:00.5Z", true},
+		{"2024-03-01T12:00:00", "2024-03-01T12:00:00Z", true},
+		{"2024-03-01 12:00:00+01:00", "2024-03-01T11:00:00Z", true},
+		{"2024-03-01", "2024-03-01T00:00:00Z", true},
+		{"2024-13-01", "", false},
+		{"1709294400", "", false},
+		{"n/a", "", false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.value, func(t *testing.T) {
+			parsed, ok := parseTimestamp(tc.value)
+			if ok != tc.ok {
+				t.Fatalf("expected timestamp: %t, found: %t", tc.ok, ok)
+			}
+			if ok == true && parsed.UTC().Format(time.RFC3339Nano) != tc.expected {
+				t.Errorf("expected %s, found %s", tc.expected, parsed.UTC().Format(time.RFC3339Nano))
+			}
+		})
+	}
+}
+
+func TestEpochTime(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		value    float64
+		unit     string
+		expected string
+	}{
+		{1709294400, EpochSeconds, "2024-03-01T12:00:00Z"},
+		{1709294400.25, EpochSeconds, "2024-03-01T12:00:00.25Z"},
+		{1709294400123, EpochMilliseconds, "2024-03-01T12:00:00.123Z"},
+		{1709294400, EpochAuto, "2024-03-01T12:00:00Z"},
+		{1709294400000, EpochAuto, "2024-03-01T12:00:00Z"},
+		{-86400, EpochSeconds, "1969-12-31T00:00:00Z"},
+	}
+	for _, tc := range cases {
+		if found := epochTime(tc.value, tc.unit).Format(time.RFC3339Nano); found != tc.expected {
+			t.Errorf("expected %v (%s) to be %s, found %s", tc.value, tc.unit, tc.expected, found)
+		}
+	}
+}
+
+func TestTimeConvert(t *testing.T) {
+	t.Parallel()
+
+	raw := `[
+		{"id":1, "created":"2024-03-01T12:00:00Z", "seen":1709294400, "born":"1990-05-17"},
+		{"id":2, "created":"2024-03-01T08:30:00-05:00", "seen":1709294400000, "born":"1991-01-02"},
+		{"id":3, "created":null, "seen":"2024-03-01T12:00:00Z", "born":"n/a"}
+	]`
+
+	cases := []conversionCase{
+		{
+			"unchanged",
+			Options{},
+			"born,created,id,seen\n" +
+				"1990-05-17,2024-03-01T12:00:00Z,1,1709294400\n" +
+				"1991-01-02,2024-03-01T08:30:00-05:00,2,1709294400000\n" +
+				"n/a,,3,2024-03-01T12:00:00Z\n",
+			false,
+		},
+		{
+			"layout",
+			Options{TimeLayout: "2006-01-02 15:04", EpochColumns: map[string]string{"seen": EpochAuto}},
+			"born,created,id,seen\n" +
+				"1990-05-17,2024-03-01 12:00,1,2024-03-01 12:00\n" +
+				"1991-01-02,2024-03-01 13:30,2,2024-03-01 12:00\n" +
+				"n/a,,3,2024-03-01 12:00\n",
+			false,
+		},
+		{
+			"time zone",
+			Options{TimeZone: time.FixedZone("EST", -5*60*60)},
+			"born,created,id,seen\n" +
+				"1990-05-17,2024-03-01T07:00:00-05:00,1,1709294400\n" +
+				"1991-01-02,2024-03-01T08:30:00-05:00,2,1709294400000\n" +
+				"n/a,,3,2024-03-01T12:00:00Z\n",
+			false,
+		},
+		{
+			"epoch columns",
+			Options{EpochColumns: map[string]string{"id": EpochSeconds}},
+			"born,created,id,seen\n" +
+				"1990-05-17,2024-03-01T12:00:00Z,1970-01-01T00:00:01Z,1709294400\n" +
+				"1991-01-02,2024-03-01T08:30:00-05:00,1970-01-01T00:00:02Z,1709294400000\n" +
+				"n/a,,1970-01-01T00:00:03Z,2024-03-01T12:00:00Z\n",
+			false,
+		},
+		{"unknown unit", Options{EpochColumns: map[string]string{"seen": "days"}}, "", true},
+	}
+	checkConversions(t, raw, cases)
+}
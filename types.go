@@ -0,0 +1,374 @@
+package fjson2csv
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// JSON value types observed for a field while indexing, as a bit set.
+const (
+	nullType uint8 = 1 << iota
+	boolType
+	intType
+	floatType
+	stringType
+	objectType
+	arrayType
+)
+
+// Maximum number of example values kept for each field.
+const max_examples int = 3
+
+// Value types of an output column.
+type ColumnType int
+
+const (
+	StringColumn ColumnType = iota
+	IntegerColumn
+	FloatColumn
+	BooleanColumn
+)
+
+// Returns the name of a column type, as given in `Options.ColumnTypes`.
+func (t ColumnType) String() string {
+	switch t {
+	case IntegerColumn:
+		return "integer"
+	case FloatColumn:
+		return "number"
+	case BooleanColumn:
+		return "boolean"
+	}
+	return "string"
+}
+
+// Describes an output column, as inferred from the values observed for its
+// field while indexing.
+type Column struct {
+	Name string
+	Type ColumnType
+
+	// Whether the field was null or missing in any record.
+	Nullable bool
+}
+
+// Describes the values observed for a field while indexing.
+type fieldStats struct {
+	types uint8
+
+	// Lengths (in characters) of the shortest and longest string values
+	minLength int
+	maxLength int
+
+	// Distinct scalar values, in the order they were observed
+	examples []interface{}
+
+	// Types of numbers found in string values (or `stringType`, for strings
+	// which are not numbers), for coercing strings to numbers
+	numeric uint8
+
+	// Whether any string value was not a timestamp
+	untimed bool
+}
+
+// Returns the type bit of a decoded JSON value.
+func typeOf(value interface{}) uint8 {
+	switch v := value.(type) {
+	case nil:
+		return nullType
+	case bool:
+		return boolType
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<63 {
+			return intType
+		}
+		return floatType
+	case string:
+		return stringType
+	case []interface{}:
+		return arrayType
+	default:
+		return objectType
+	}
+}
+
+// Records the type, length and (for the first few distinct values) the value
+// observed for a field.
+func (c *converter) observe(key string, value interface{}) {
+	if c.fields == nil {
+		c.fields = map[string]*fieldStats{}
+	}
+	stats, ok := c.fields[key]
+	if ok == false {
+		stats = &fieldStats{minLength: -1}
+		c.fields[key] = stats
+	}
+
+	kind := typeOf(value)
+	stats.types |= kind
+	if kind == stringType {
+		stats.numeric |= numericType(value.(string))
+		if _, ok := parseTimestamp(value.(string)); ok == false {
+			stats.untimed = true
+		}
+		length := utf8.RuneCountInString(value.(string))
+		if stats.minLength < 0 || length < stats.minLength {
+			stats.minLength = length
+		}
+		if length > stats.maxLength {
+			stats.maxLength = length
+		}
+	}
+	if len(stats.examples) < max_examples && kind&(nullType|objectType|arrayType) == 0 {
+		for _, example := range stats.examples {
+			if example == value {
+				return
+			}
+		}
+		stats.examples = append(stats.examples, value)
+	}
+}
+
+// Returns the type bit of the number in a string, or `stringType` when the
+// string is not a number.
+func numericType(value string) uint8 {
+	if value == "" || strings.IndexByte("+-.0123456789", value[0]) < 0 {
+		return stringType
+	}
+	if n, err := strconv.ParseFloat(value, 64); err == nil {
+		return typeOf(n)
+	}
+	return stringType
+}
+
+// Reports whether a field's values have conflicting types. Integers and
+// fractional numbers do not conflict, and neither does null.
+func conflicting(types uint8) bool {
+	kinds := 0
+	for _, kind := range []uint8{boolType, intType | floatType, stringType, objectType, arrayType} {
+		if types&kind != 0 {
+			kinds++
+		}
+	}
+	return kinds > 1
+}
+
+// Returns the statistics of a field, which are empty for unknown fields.
+func (c *converter) stats(key string) *fieldStats {
+	if stats, ok := c.fields[key]; ok == true {
+		return stats
+	}
+	return &fieldStats{minLength: -1}
+}
+
+// Infers the column type of a field. Numeric fields are integers unless a
+// fractional value was seen, and fields with only null values are strings.
+// Fields with conflicting values are strings, or numbers under the
+// `NumberPolicy`, and epoch columns are strings (of formatted timestamps). A
+// column is nullable when its field was null or missing in any record.
+func (c *converter) column(key string) Column {
+	col := Column{Name: key, Type: StringColumn}
+	stats := c.stats(key)
+	types := stats.types
+	nullable := types&nullType != 0
+	if _, ok := c.epochs[key]; ok == true {
+		types = stringType
+	} else if conflicting(types) && c.conflictPolicy(key) == NumberPolicy {
+		// Only numbers (including those in strings) are kept, and any other
+		// values become null
+		if types&(boolType|objectType|arrayType) != 0 || stats.numeric&stringType != 0 {
+			nullable = true
+		}
+		types = types&(intType|floatType) | stats.numeric&(intType|floatType)
+		if types == 0 {
+			types = floatType
+		}
+	}
+	switch types &^ nullType {
+	case intType:
+		col.Type = IntegerColumn
+	case intType | floatType, floatType:
+		col.Type = FloatColumn
+	case boolType:
+		col.Type = BooleanColumn
+	}
+	col.Nullable = nullable || c.Keys[key] < c.records
+	return col
+}
+
+// Returns the inferred (or, given a schema, declared) types of all output
+// columns, in order.
+func (c *converter) columns() []Column {
+	if c.schema != nil {
+		return c.schema.output
+	}
+	columns := make([]Column, len(c.sorted))
+	for i, key := range c.sorted {
+		columns[i] = c.column(key)
+	}
+	return columns
+}
+
+// Converts a decoded JSON value into the Go type of the column (int64,
+// float64, bool or string). Numeric strings are converted to numbers, and
+// numbers and booleans to strings. Null values, and values which cannot be
+// converted, are returned as nil.
+func (col Column) Convert(value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	if s, ok := value.(string); ok == true && (col.Type == IntegerColumn || col.Type == FloatColumn) {
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil
+		}
+		value = n
+	}
+
+	switch col.Type {
+	case IntegerColumn:
+		if v, ok := value.(float64); ok == true {
+			return int64(v)
+		}
+		return nil
+	case FloatColumn:
+		if v, ok := value.(float64); ok == true {
+			return v
+		}
+		return nil
+	case BooleanColumn:
+		if v, ok := value.(bool); ok == true {
+			return v
+		}
+		return nil
+	}
+
+	switch v := value.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return toString(value)
+}
+
+// Formats a column value (as returned by `Convert`) as text. Null values are
+// formatted as an empty string.
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return value.(string)
+}
+
+// A non-null value of a column, as passed to a `Formatter`.
+type Value struct {
+	// Type of the value's column
+	Type ColumnType
+	// The value converted to the Go type of its column (int64, float64,
+	// bool or string), as by `Column.Convert`
+	Data interface{}
+
+	text string
+}
+
+// Returns the text a value is written as by default, including any boolean
+// spellings given in the options.
+func (v Value) String() string {
+	return v.text
+}
+
+// Formats the values of columns in CSV, XLSX, Markdown and HTML output, given
+// the name of each value's column. Formatters can fall back to the default
+// formatting with `Value.String`, and returning an error stops the
+// conversion. Null and missing values are not formatted. XLSX cells keep
+// their type unless their value is formatted differently, and are then text.
+type Formatter func(column string, v Value) (string, error)
+
+// Formats column values as text, for text output formats.
+type valueFormatter struct {
+	trueValue  string
+	falseValue string
+	fn         Formatter
+}
+
+func newValueFormatter(opts Options) valueFormatter {
+	return valueFormatter{trueValue: opts.TrueValue, falseValue: opts.FalseValue, fn: opts.Formatter}
+}
+
+// Formats a value converted to the type of its column. Null values are
+// formatted as an empty string.
+func (f valueFormatter) format(col Column, value interface{}) (string, error) {
+	if value == nil {
+		return "", nil
+	}
+	text := formatValue(value)
+	if b, ok := value.(bool); ok == true {
+		if b == true && f.trueValue != "" {
+			text = f.trueValue
+		} else if b == false && f.falseValue != "" {
+			text = f.falseValue
+		}
+	}
+	if f.fn == nil {
+		return text, nil
+	}
+	return f.fn(col.Name, Value{Type: col.Type, Data: value, text: text})
+}
+
+// Returns the conflict policy of a column.
+func (c *converter) conflictPolicy(key string) string {
+	if policy, ok := c.policies[key]; ok == true {
+		return policy
+	}
+	if c.policy == "" {
+		return StringPolicy
+	}
+	return c.policy
+}
+
+// Applies the conflict policies of fields whose values have conflicting
+// types, failing or reporting conflicts as required.
+func (c *converter) resolveConflicts() {
+	for _, policy := range append([]string{c.policy}, policyValues(c.policies)...) {
+		switch policy {
+		case "", StringPolicy, NumberPolicy, ReportPolicy, FailPolicy:
+		default:
+			c.err = fmt.Errorf("unsupported conflict policy: %s", policy)
+			return
+		}
+	}
+
+	for _, key := range c.sorted {
+		types := c.stats(key).types
+		if _, ok := c.epochs[key]; ok == true || conflicting(types) == false {
+			continue
+		}
+		conflict := fmt.Sprintf("column '%s': conflicting types %s", key, strings.Join(jsonTypes(types&^nullType), ", "))
+		switch c.conflictPolicy(key) {
+		case FailPolicy:
+			c.err = fmt.Errorf("type conflict: %s", conflict)
+			return
+		case ReportPolicy:
+			fmt.Fprintf(c.log, "%s\n", conflict)
+		}
+	}
+}
+
+func policyValues(policies map[string]string) []string {
+	values := []string{}
+	for _, policy := range policies {
+		values = append(values, policy)
+	}
+	return values
+}
@@ -0,0 +1,202 @@
+package fjson2csv
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestColumnInference(t *testing.T) {
+	t.Parallel()
+
+	c := converter{Keys: map[string]int64{}}
+	records := []map[string]interface{}{
+		{"id": float64(1), "ratio": float64(1), "zip": "02134", "ok": true, "note": nil, "tags": []interface{}{}},
+		{"id": float64(2), "ratio": 0.5, "zip": float64(2134), "ok": false},
+	}
+	for _, record := range records {
+		extractKeys(record, &c)
+	}
+
+	cases := []struct {
+		key      string
+		kind     ColumnType
+		nullable bool
+	}{
+		{"id", IntegerColumn, false},
+		{"ratio", FloatColumn, false},
+		{"zip", StringColumn, false},
+		{"ok", BooleanColumn, false},
+		{"note", StringColumn, true},
+		{"tags", StringColumn, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.key, func(t *testing.T) {
+			col := c.column(tc.key)
+			if col.Type != tc.kind || col.Nullable != tc.nullable {
+				t.Errorf("expected kind %d (nullable: %t), found kind %d (nullable: %t)", tc.kind, tc.nullable, col.Type, col.Nullable)
+			}
+		})
+	}
+}
+
+func TestFieldStats(t *testing.T) {
+	t.Parallel()
+
+	c := converter{Keys: map[string]int64{}}
+	for _, value := range []interface{}{"héllo", "hi", "hi", float64(3), nil, "longest", "ignored"} {
+		c.observe("value", value)
+	}
+
+	stats := c.stats("value")
+	if stats.types != stringType|intType|nullType {
+		t.Errorf("unexpected types: %b", stats.types)
+	}
+	if stats.minLength != 2 || stats.maxLength != 7 {
+		t.Errorf("expected lengths 2 to 7, found %d to %d", stats.minLength, stats.maxLength)
+	}
+	if len(stats.examples) != 3 || stats.examples[0] != "héllo" || stats.examples[1] != "hi" || stats.examples[2] != float64(3) {
+		t.Errorf("unexpected examples: %v", stats.examples)
+	}
+	if c.stats("unknown").minLength != -1 {
+		t.Errorf("expected no lengths for an unknown field")
+	}
+	if stats.numeric != stringType {
+		t.Errorf("unexpected types of numeric strings: %b", stats.numeric)
+	}
+	for value, expected := range map[string]uint8{"42": intType, "-0.5": floatType, "1e3": intType, "": stringType, "n/a": stringType, "Infinity": stringType} {
+		if kind := numericType(value); kind != expected {
+			t.Errorf("expected '%s' to have type %b, found %b", value, expected, kind)
+		}
+	}
+}
+
+func TestTypeConflicts(t *testing.T) {
+	t.Parallel()
+
+	raw := `[
+		{"zip":"02134", "n":1},
+		{"zip":2134, "n":"2"},
+		{"zip":"n/a", "n":true}
+	]`
+	strings_csv := "n,zip\n1,02134\n2,2134\ntrue,n/a\n"
+
+	cases := []struct {
+		name      string
+		policy    string
+		policies  map[string]string
+		expected  string
+		conflicts string
+		willFail  bool
+	}{
+		{"default", "", nil, strings_csv, "", false},
+		{"string", StringPolicy, nil, strings_csv, "", false},
+		{"number", NumberPolicy, nil, "n,zip\n1,2134\n2,2134\n,\n", "", false},
+		{"column override", StringPolicy, map[string]string{"zip": NumberPolicy}, "n,zip\n1,2134\n2,2134\ntrue,\n", "", false},
+		{"report", ReportPolicy, nil, strings_csv, "column 'n': conflicting types string, integer, boolean\ncolumn 'zip': conflicting types string, integer\n", false},
+		{"report column", "", map[string]string{"n": ReportPolicy}, strings_csv, "column 'n': conflicting types string, integer, boolean\n", false},
+		{"fail", FailPolicy, nil, "", "", true},
+		{"fail column", NumberPolicy, map[string]string{"zip": FailPolicy}, "", "", true},
+		{"unknown policy", "ignore", nil, "", "", true},
+		{"unknown column policy", "", map[string]string{"zip": "ignore"}, "", "", true},
+	}
+	for _, tc := range cases {
+		for name, convert := range conversions {
+			t.Run(tc.name+" "+name, func(t *testing.T) {
+				buffer := bytes.Buffer{}
+				conflicts := bytes.Buffer{}
+				opts := Options{ConflictPolicy: tc.policy, ColumnPolicies: tc.policies, ViolationLog: &conflicts}
+				err := convert(strings.NewReader(raw), &buffer, opts)
+				if (err != nil) != tc.willFail {
+					t.Fatalf("expected failure: %t, found: %v", tc.willFail, err)
+				}
+				if tc.willFail {
+					return
+				}
+				checkOutput(t, "conversion", tc.expected, buffer.String())
+				if conflicts.String() != tc.conflicts {
+					t.Logf("unexpected conflicts")
+					t.Logf("Expected:\n%s", tc.conflicts)
+					t.Logf("Found:\n%s", conflicts.String())
+					t.FailNow()
+				}
+			})
+		}
+	}
+}
+
+func TestFloatFormatting(t *testing.T) {
+	t.Parallel()
+
+	// Floats are written as decimals, however large or small
+	raw := `[
+		{"price":1234567.5, "rate":0.00001},
+		{"price":25000000, "rate":-0.000025}
+	]`
+	expected := "price,rate\n1234567.5,0.00001\n25000000,-0.000025\n"
+
+	checkConversion(t, raw, Options{}, expected)
+}
+
+func TestTypeConflictsXlsx(t *testing.T) {
+	t.Parallel()
+
+	raw := `[
+		{"zip":"02134", "n":1},
+		{"zip":2134, "n":"2"},
+		{"zip":"n/a", "n":true}
+	]`
+	header := `A1="n" B1="zip" `
+
+	cases := []struct {
+		name     string
+		policy   string
+		expected string
+	}{
+		{"string", StringPolicy, header + `A2="1" B2="02134" A3="2" B3="2134" A4="true" B4="n/a"`},
+		{"number", NumberPolicy, header + `A2=1 B2=2134 A3=2 B3=2134`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			buffer := bytes.Buffer{}
+			opts := Options{Format: XlsxFormat, ConflictPolicy: tc.policy}
+			if err := BufferedConvert(strings.NewReader(raw), &buffer, opts); err != nil {
+				t.Fatalf("conversion failure: %s", err.Error())
+			}
+			if found := readCells(t, buffer.Bytes()); found != tc.expected {
+				t.Errorf("expected cells %s, found %s", tc.expected, found)
+			}
+		})
+	}
+}
+
+func TestValueFormatter(t *testing.T) {
+	t.Parallel()
+
+	raw := `[
+		{"email":"jane@example.com", "price":12.5, "active":true},
+		{"email":"jo@example.com", "price":3, "active":false},
+		{"email":null, "active":true}
+	]`
+	formatter := func(column string, v Value) (string, error) {
+		switch {
+		case column == "email":
+			return "***" + v.String()[strings.Index(v.String(), "@"):], nil
+		case v.Type == FloatColumn:
+			return fmt.Sprintf("$%.2f", v.Data.(float64)), nil
+		}
+		return v.String(), nil
+	}
+	failing := func(column string, v Value) (string, error) {
+		return "", fmt.Errorf("unformattable %s", column)
+	}
+
+	cases := []conversionCase{
+		{"default", Options{}, "active,email,price\ntrue,jane@example.com,12.5\nfalse,jo@example.com,3\ntrue,,\n", false},
+		{"boolean spellings", Options{TrueValue: "1", FalseValue: "0"}, "active,email,price\n1,jane@example.com,12.5\n0,jo@example.com,3\n1,,\n", false},
+		{"formatter", Options{TrueValue: "Y", FalseValue: "N", NullValue: "NULL", Formatter: formatter}, "active,email,price\nY,***@example.com,$12.50\nN,***@example.com,$3.00\nY,NULL,\n", false},
+		{"failing formatter", Options{Formatter: failing}, "", true},
+	}
+	checkConversions(t, raw, cases)
+}
@@ -0,0 +1,265 @@
+package fjson2csv
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Excel limits a worksheet to 1,048,576 rows, including its header row.
+const xlsx_max_rows int = 1048576
+
+const (
+	xlsx_main_ns string = "http://schemas.openxmlformats.org/spreadsheetml/2006/main"
+	xlsx_rel_ns  string = "http://schemas.openxmlformats.org/officeDocument/2006/relationships"
+	xlsx_pkg_ns  string = "http://schemas.openxmlformats.org/package/2006/relationships"
+	xlsx_xml     string = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n"
+)
+
+// Writes records as an Excel (Office Open XML) workbook.
+//
+// Worksheets are streamed into the archive as records arrive. Once a sheet
+// reaches Excel's row limit, it is closed and a new sheet is started with its
+// own header row. Parts describing the workbook as a whole (including the
+// shared string table) are only known once all records have been written, so
+// they are added when the writer is closed.
+type xlsxEncoder struct {
+	buffer  *bufio.Writer
+	archive *zip.Writer
+	sheet   io.Writer
+	columns []Column
+	letters []string
+	null    string
+	missing string
+	values  valueFormatter
+	row     int
+	sheets  int
+	maxRows int
+	strings map[string]int
+	table   []string
+	count   int
+	err     error
+}
+
+func newXlsxEncoder(w io.Writer, size int) *xlsxEncoder {
+	buffer := bufio.NewWriterSize(w, size)
+	return &xlsxEncoder{
+		buffer:  buffer,
+		archive: zip.NewWriter(buffer),
+		maxRows: xlsx_max_rows,
+		strings: map[string]int{},
+		table:   []string{},
+	}
+}
+
+func (x *xlsxEncoder) WriteHeader(columns []Column) error {
+	x.columns = columns
+	x.letters = make([]string, len(columns))
+	for i := range columns {
+		x.letters[i] = xlsxColumn(i)
+	}
+	return x.startSheet()
+}
+
+func (x *xlsxEncoder) WriteRow(record map[string]interface{}) error {
+	if x.err != nil {
+		return x.err
+	}
+	if x.row >= x.maxRows {
+		x.endSheet()
+		if err := x.startSheet(); err != nil {
+			return err
+		}
+	}
+
+	x.row++
+	row := bytes.Buffer{}
+	fmt.Fprintf(&row, `<row r="%d">`, x.row)
+	for i, col := range x.columns {
+		ref := x.letters[i] + strconv.Itoa(x.row)
+		value, ok := record[col.Name]
+		if ok == false {
+			x.writeText(&row, ref, x.missing)
+			continue
+		}
+		if value = col.Convert(value); value == nil {
+			x.writeText(&row, ref, x.null)
+			continue
+		}
+
+		// Values formatted differently from their default text (by the
+		// formatter, or the spellings of booleans) are written as text
+		text, err := x.values.format(col, value)
+		if err != nil {
+			return err
+		}
+		if text != formatValue(value) {
+			x.writeText(&row, ref, text)
+			continue
+		}
+
+		switch value := value.(type) {
+		case string:
+			x.writeText(&row, ref, value)
+		case int64:
+			fmt.Fprintf(&row, `<c r="%s"><v>%d</v></c>`, ref, value)
+		case float64:
+			fmt.Fprintf(&row, `<c r="%s"><v>%s</v></c>`, ref, strconv.FormatFloat(value, 'f', -1, 64))
+		case bool:
+			v := 0
+			if value {
+				v = 1
+			}
+			fmt.Fprintf(&row, `<c r="%s" t="b"><v>%d</v></c>`, ref, v)
+		default:
+			x.writeText(&row, ref, toString(value))
+		}
+	}
+	row.WriteString("</row>")
+	_, x.err = x.sheet.Write(row.Bytes())
+	return x.err
+}
+
+func (x *xlsxEncoder) Close() error {
+	x.endSheet()
+	if x.sheets == 0 {
+		// Always produce a workbook that Excel will open
+		x.startSheet()
+		x.endSheet()
+	}
+
+	// Shared strings
+	sst := bytes.Buffer{}
+	fmt.Fprintf(&sst, `%s<sst xmlns="%s" count="%d" uniqueCount="%d">`, xlsx_xml, xlsx_main_ns, x.count, len(x.table))
+	for _, s := range x.table {
+		sst.WriteString(`<si><t xml:space="preserve">`)
+		xml.EscapeText(&sst, []byte(s))
+		sst.WriteString(`</t></si>`)
+	}
+	sst.WriteString(`</sst>`)
+	x.writePart("xl/sharedStrings.xml", sst.String())
+
+	// Workbook, relationships and content types
+	sheets := bytes.Buffer{}
+	rels := bytes.Buffer{}
+	types := bytes.Buffer{}
+	for i := 1; i <= x.sheets; i++ {
+		fmt.Fprintf(&sheets, `<sheet name="Sheet%d" sheetId="%d" r:id="rId%d"/>`, i, i, i)
+		fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="%s/worksheet" Target="worksheets/sheet%d.xml"/>`, i, xlsx_rel_ns, i)
+		fmt.Fprintf(&types, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, i)
+	}
+	fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="%s/styles" Target="styles.xml"/>`, x.sheets+1, xlsx_rel_ns)
+	fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="%s/sharedStrings" Target="sharedStrings.xml"/>`, x.sheets+2, xlsx_rel_ns)
+
+	x.writePart("xl/workbook.xml", fmt.Sprintf(`%s<workbook xmlns="%s" xmlns:r="%s"><sheets>%s</sheets></workbook>`,
+		xlsx_xml, xlsx_main_ns, xlsx_rel_ns, sheets.String()))
+	x.writePart("xl/_rels/workbook.xml.rels", fmt.Sprintf(`%s<Relationships xmlns="%s">%s</Relationships>`,
+		xlsx_xml, xlsx_pkg_ns, rels.String()))
+	x.writePart("xl/styles.xml", xlsx_xml+xlsxStyles)
+	x.writePart("_rels/.rels", fmt.Sprintf(`%s<Relationships xmlns="%s"><Relationship Id="rId1" Type="%s/officeDocument" Target="xl/workbook.xml"/></Relationships>`,
+		xlsx_xml, xlsx_pkg_ns, xlsx_rel_ns))
+	x.writePart("[Content_Types].xml", fmt.Sprintf(`%s<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">`+
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>`+
+		`<Default Extension="xml" ContentType="application/xml"/>`+
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>`+
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`+
+		`<Override PartName="/xl/sharedStrings.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sharedStrings+xml"/>`+
+		`%s</Types>`, xlsx_xml, types.String()))
+
+	if x.err == nil {
+		x.err = x.archive.Close()
+	}
+	if x.err == nil {
+		x.err = x.buffer.Flush()
+	}
+	return x.err
+}
+
+// Opens a new worksheet and writes the (bold, frozen) header row to it.
+func (x *xlsxEncoder) startSheet() error {
+	if x.err != nil {
+		return x.err
+	}
+	x.sheets++
+	x.row = 1
+	x.sheet, x.err = x.archive.Create(fmt.Sprintf("xl/worksheets/sheet%d.xml", x.sheets))
+	if x.err != nil {
+		return x.err
+	}
+
+	header := bytes.Buffer{}
+	fmt.Fprintf(&header, `%s<worksheet xmlns="%s"><sheetViews><sheetView workbookViewId="0">`, xlsx_xml, xlsx_main_ns)
+	header.WriteString(`<pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/>`)
+	header.WriteString(`</sheetView></sheetViews><sheetData><row r="1">`)
+	for i, col := range x.columns {
+		fmt.Fprintf(&header, `<c r="%s1" t="s" s="1"><v>%d</v></c>`, x.letters[i], x.sharedString(col.Name))
+	}
+	header.WriteString(`</row>`)
+	_, x.err = x.sheet.Write(header.Bytes())
+	return x.err
+}
+
+// Writes text as a shared string cell. Empty cells are left out.
+func (x *xlsxEncoder) writeText(row *bytes.Buffer, ref string, text string) {
+	if text != "" {
+		fmt.Fprintf(row, `<c r="%s" t="s"><v>%d</v></c>`, ref, x.sharedString(text))
+	}
+}
+
+func (x *xlsxEncoder) endSheet() {
+	if x.err == nil && x.sheet != nil {
+		_, x.err = io.WriteString(x.sheet, `</sheetData></worksheet>`)
+	}
+	x.sheet = nil
+}
+
+func (x *xlsxEncoder) writePart(name string, content string) {
+	if x.err != nil {
+		return
+	}
+	var part io.Writer
+	if part, x.err = x.archive.Create(name); x.err == nil {
+		_, x.err = io.WriteString(part, content)
+	}
+}
+
+// Returns the index of a string in the shared string table, adding it when
+// it is seen for the first time.
+func (x *xlsxEncoder) sharedString(s string) int {
+	x.count++
+	if index, ok := x.strings[s]; ok == true {
+		return index
+	}
+	index := len(x.table)
+	x.strings[s] = index
+	x.table = append(x.table, s)
+	return index
+}
+
+// Converts a zero-based column index into its spreadsheet name (eg. 27 is "AB").
+func xlsxColumn(index int) string {
+	name := ""
+	for index >= 0 {
+		name = string(rune('A'+index%26)) + name
+		index = index/26 - 1
+	}
+	return name
+}
+
+// Minimal stylesheet. The second cell format (s="1") is the bold header style.
+var xlsxStyles string = strings.Join([]string{
+	`<styleSheet xmlns="` + xlsx_main_ns + `">`,
+	`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>`,
+	`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>`,
+	`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>`,
+	`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>`,
+	`<cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>`,
+	`<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs>`,
+	`<cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles>`,
+	`</styleSheet>`,
+}, "")
@@ -0,0 +1,192 @@
+package fjson2csv
+
+import (
+	"archive/zip"
+	"bytes"
+	"html"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Reads every part of a zip archive into memory, keyed by name.
+func readArchive(t *testing.T, data []byte) map[string]string {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("invalid archive: %s", err.Error())
+	}
+	parts := map[string]string{}
+	for _, file := range archive.File {
+		rc, err := file.Open()
+		if err != nil {
+			t.Fatalf("unreadable part '%s': %s", file.Name, err.Error())
+		}
+		raw, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		parts[file.Name] = string(raw)
+	}
+	return parts
+}
+
+// Reads the cells of a workbook's first sheet, as "A1=value" with string
+// values quoted, in order.
+func readCells(t *testing.T, data []byte) string {
+	parts := readArchive(t, data)
+	table := []string{}
+	for _, match := range regexp.MustCompile(`<si><t xml:space="preserve">(.*?)</t></si>`).FindAllStringSubmatch(parts["xl/sharedStrings.xml"], -1) {
+		table = append(table, html.UnescapeString(match[1]))
+	}
+	cells := []string{}
+	for _, match := range regexp.MustCompile(`<c r="(\w+)"( t="(\w)")?( s="\d+")?><v>(.*?)</v></c>`).FindAllStringSubmatch(parts["xl/worksheets/sheet1.xml"], -1) {
+		value := match[5]
+		if match[3] == "s" {
+			index, _ := strconv.Atoi(value)
+			value = strconv.Quote(table[index])
+		}
+		cells = append(cells, match[1]+"="+value)
+	}
+	return strings.Join(cells, " ")
+}
+
+func TestXlsxConvert(t *testing.T) {
+	t.Parallel()
+
+	raw := `[
+		{"zip":"02134", "count":42, "active":true},
+		{"zip":"02134", "ratio":0.5}
+	]`
+
+	for name, data := range convertBoth(t, raw, Options{Format: XlsxFormat}) {
+		parts := readArchive(t, data)
+		for _, part := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/styles.xml"} {
+			if _, ok := parts[part]; ok == false {
+				t.Errorf("%s: missing part '%s'", name, part)
+			}
+		}
+
+		// Columns: zip, active, count, ratio
+		sheet := parts["xl/worksheets/sheet1.xml"]
+		expected := []string{
+			`<c r="A1" t="s" s="1"><v>0</v></c>`,
+			`<c r="A2" t="s"><v>4</v></c>`,
+			`<c r="B2" t="b"><v>1</v></c>`,
+			`<c r="C2"><v>42</v></c>`,
+			`<row r="3"><c r="A3" t="s"><v>4</v></c><c r="D3"><v>0.5</v></c></row>`,
+		}
+		for _, cell := range expected {
+			if strings.Contains(sheet, cell) == false {
+				t.Errorf("%s: sheet is missing '%s'", name, cell)
+			}
+		}
+		if strings.Contains(parts["xl/sharedStrings.xml"], `<si><t xml:space="preserve">02134</t></si>`) == false {
+			t.Errorf("%s: shared strings are missing a value", name)
+		}
+	}
+}
+
+func TestXlsxValues(t *testing.T) {
+	t.Parallel()
+
+	raw := `[
+		{"id":1, "tags":["a","b"], "meta":{"k":1}, "ok":true, "note":null},
+		{"id":2, "ok":false}
+	]`
+	header := `A1="id" B1="ok" C1="meta" D1="note" E1="tags" `
+
+	cases := []struct {
+		name     string
+		opts     Options
+		expected string
+	}{
+		{
+			"defaults",
+			Options{},
+			header + `A2=1 B2=1 A3=2 B3=0`,
+		},
+		{
+			"null and missing",
+			Options{NullValue: "NULL", MissingValue: "-"},
+			header + `A2=1 B2=1 D2="NULL" A3=2 B3=0 C3="-" D3="-" E3="-"`,
+		},
+		{
+			"booleans",
+			Options{TrueValue: "Y", FalseValue: "N"},
+			header + `A2=1 B2="Y" A3=2 B3="N"`,
+		},
+		{
+			"formatter",
+			Options{Formatter: func(column string, v Value) (string, error) {
+				return column + ":" + v.String(), nil
+			}},
+			header + `A2="id:1" B2="ok:true" C2="meta:" E2="tags:" A3="id:2" B3="ok:false"`,
+		},
+		{
+			"formatter keeping defaults",
+			Options{Formatter: func(column string, v Value) (string, error) {
+				if column == "id" && v.String() == "2" {
+					return "two", nil
+				}
+				return v.String(), nil
+			}},
+			header + `A2=1 B2=1 A3="two" B3=0`,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			buffer := bytes.Buffer{}
+			tc.opts.Format = XlsxFormat
+			if err := BufferedConvert(strings.NewReader(raw), &buffer, tc.opts); err != nil {
+				t.Fatalf("conversion failure: %s", err.Error())
+			}
+			if found := readCells(t, buffer.Bytes()); found != tc.expected {
+				t.Errorf("expected cells %s, found %s", tc.expected, found)
+			}
+		})
+	}
+}
+
+func TestXlsxSheetRollover(t *testing.T) {
+	t.Parallel()
+
+	buffer := bytes.Buffer{}
+	x := newXlsxEncoder(&buffer, default_write_buffer_size)
+	x.maxRows = 3
+	x.WriteHeader([]Column{{Name: "id", Type: IntegerColumn}})
+	for i := 0; i < 5; i++ {
+		if err := x.WriteRow(map[string]interface{}{"id": float64(i)}); err != nil {
+			t.Fatalf("write failure: %s", err.Error())
+		}
+	}
+	if err := x.Close(); err != nil {
+		t.Fatalf("close failure: %s", err.Error())
+	}
+
+	parts := readArchive(t, buffer.Bytes())
+	for sheet, expected := range map[string][]string{
+		"xl/worksheets/sheet1.xml": {`<v>0</v></c></row><row r="3"><c r="A3"><v>1</v>`},
+		"xl/worksheets/sheet2.xml": {`<c r="A1" t="s" s="1">`, `<c r="A2"><v>2</v>`, `<c r="A3"><v>3</v>`},
+		"xl/worksheets/sheet3.xml": {`<c r="A1" t="s" s="1">`, `<c r="A2"><v>4</v>`},
+	} {
+		for _, fragment := range expected {
+			if strings.Contains(parts[sheet], fragment) == false {
+				t.Errorf("%s is missing '%s'", sheet, fragment)
+			}
+		}
+	}
+	if strings.Count(parts["xl/workbook.xml"], "<sheet ") != 3 {
+		t.Errorf("expected workbook with 3 sheets, found:\n%s", parts["xl/workbook.xml"])
+	}
+}
+
+func TestXlsxColumn(t *testing.T) {
+	t.Parallel()
+
+	cases := map[int]string{0: "A", 25: "Z", 26: "AA", 27: "AB", 701: "ZZ", 702: "AAA"}
+	for index, expected := range cases {
+		if column := xlsxColumn(index); column != expected {
+			t.Errorf("column %d: expected '%s', found '%s'", index, expected, column)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package fjson2csv
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Input format of YAML records.
+const YamlFormat string = "yaml"
+
+// Reads records from YAML documents, each either a sequence of mappings or a
+// single mapping.
+//
+// Documents are decoded one at a time, so a file of many small documents is
+// streamed while a single sequence is held in memory while it is read.
+type yamlSource struct {
+	r       io.ReadSeeker
+	dec     *yaml.Decoder
+	size    int
+	binary  string
+	pending []*yaml.Node
+}
+
+// Creates a source reading records from YAML. Scalars are converted to their
+// JSON equivalents: integers and floats become float64, timestamps are
+// formatted as RFC 3339 (or as a date, for dates without a time) and binary
+// values are encoded as text (see `Options.BinaryEncoding`).
+func NewYamlSource(r io.ReadSeeker, opts Options) RecordSource {
+	rsize, _ := getBufferSizes(opts)
+	return &yamlSource{r: r, size: rsize, binary: opts.BinaryEncoding}
+}
+
+func (s *yamlSource) Next() (Record, error) {
+	if s.dec == nil {
+		s.dec = yaml.NewDecoder(bufio.NewReaderSize(s.r, s.size))
+	}
+
+	// Read documents until one has records
+	for len(s.pending) == 0 {
+		var document yaml.Node
+		if err := s.dec.Decode(&document); err == io.EOF {
+			return nil, io.EOF
+		} else if err != nil {
+			return nil, yamlError(err)
+		}
+		if len(document.Content) == 0 {
+			continue
+		}
+		root := resolveAlias(document.Content[0])
+		switch {
+		case root.Kind == yaml.SequenceNode:
+			s.pending = root.Content
+		case root.Kind == yaml.MappingNode:
+			s.pending = []*yaml.Node{root}
+		case root.Kind == yaml.ScalarNode && root.ShortTag() == "!!null":
+			// Empty document
+		default:
+			return nil, fmt.Errorf("malformed YAML: line %d: documents must be mappings or sequences of mappings", root.Line)
+		}
+	}
+
+	node := resolveAlias(s.pending[0])
+	s.pending = s.pending[1:]
+	if node.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("malformed YAML: line %d: records must be mappings", node.Line)
+	}
+	record := Record{}
+	err := s.eachMember(node, 1, func(key string, value interface{}) {
+		for i, field := range record {
+			if field.Key == key {
+				record[i].Value = value
+				return
+			}
+		}
+		record = append(record, Field{key, value})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (s *yamlSource) Reset() error {
+	if _, err := s.r.Seek(0, 0); err != nil {
+		return fmt.Errorf("file read failure: %s", err.Error())
+	}
+	s.dec = nil
+	s.pending = nil
+	return nil
+}
+
+// Invokes a callback with each member of a mapping, including those merged
+// into it with "<<" keys. Members of the mapping itself take precedence over
+// merged ones.
+func (s *yamlSource) eachMember(node *yaml.Node, depth int, fn func(key string, value interface{})) error {
+	merged := map[string]interface{}{}
+	keys := []string{}
+	own := map[string]bool{}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keyNode, valueNode := resolveAlias(node.Content[i]), node.Content[i+1]
+		if keyNode.ShortTag() == "!!merge" {
+			sources := []*yaml.Node{resolveAlias(valueNode)}
+			if sources[0].Kind == yaml.SequenceNode {
+				sources = sources[0].Content
+			}
+			for _, source := range sources {
+				source = resolveAlias(source)
+				if source.Kind != yaml.MappingNode {
+					return fmt.Errorf("malformed YAML: line %d: only mappings can be merged", source.Line)
+				}
+				err := s.eachMember(source, depth+1, func(key string, value interface{}) {
+					if _, ok := merged[key]; ok == false {
+						keys = append(keys, key)
+						merged[key] = value
+					}
+				})
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		key, err := s.convert(keyNode, depth+1)
+		if err != nil {
+			return err
+		}
+		value, err := s.convert(valueNode, depth+1)
+		if err != nil {
+			return err
+		}
+		fn(mapKey(key), value)
+		own[mapKey(key)] = true
+	}
+
+	for _, key := range keys {
+		if own[key] == false {
+			fn(key, merged[key])
+		}
+	}
+	return nil
+}
+
+// Converts a node into its JSON equivalent.
+func (s *yamlSource) convert(node *yaml.Node, depth int) (interface{}, error) {
+	if depth > binary_max_depth {
+		return nil, fmt.Errorf("malformed YAML: line %d: values nested too deeply", node.Line)
+	}
+	node = resolveAlias(node)
+
+	switch node.Kind {
+	case yaml.MappingNode:
+		m := map[string]interface{}{}
+		err := s.eachMember(node, depth, func(key string, value interface{}) {
+			m[key] = value
+		})
+		return m, err
+	case yaml.SequenceNode:
+		values := []interface{}{}
+		for _, item := range node.Content {
+			value, err := s.convert(item, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	}
+
+	switch node.ShortTag() {
+	case "!!null":
+		return nil, nil
+	case "!!bool":
+		var value bool
+		err := node.Decode(&value)
+		return value, yamlError(err)
+	case "!!int", "!!float":
+		var value interface{}
+		if err := node.Decode(&value); err != nil {
+			return nil, yamlError(err)
+		}
+		switch v := value.(type) {
+		case int:
+			return float64(v), nil
+		case int64:
+			return float64(v), nil
+		case uint64:
+			return float64(v), nil
+		case float64:
+			return v, nil
+		}
+		return nil, fmt.Errorf("malformed YAML: line %d: invalid number '%s'", node.Line, node.Value)
+	case "!!timestamp":
+		var value time.Time
+		if err := node.Decode(&value); err != nil {
+			return nil, yamlError(err)
+		}
+		if strings.ContainsAny(node.Value, ":") == false {
+			return value.Format("2006-01-02"), nil
+		}
+		return value.UTC().Format(time.RFC3339Nano), nil
+	case "!!binary":
+		data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(node.Value), ""))
+		if err != nil {
+			return nil, fmt.Errorf("malformed YAML: line %d: invalid binary value", node.Line)
+		}
+		return encodeBinary(data, s.binary), nil
+	}
+	return node.Value, nil
+}
+
+// Returns the node an alias refers to, or the node itself.
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+	return node
+}
+
+// Rewords an error from the YAML decoder.
+func yamlError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("malformed YAML: %s", strings.TrimPrefix(err.Error(), "yaml: "))
+}
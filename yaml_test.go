@@ -0,0 +1,79 @@
+package fjson2csv
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestYamlSource(t *testing.T) {
+	t.Parallel()
+
+	sequence := `
+- name: web-1
+  port: 8080
+  ratio: 0.5
+  enabled: yes
+- name: web-2
+  enabled: true
+  owner: ~
+  tags: [a, b]
+`
+	documents := `---
+name: db-1
+created: 2001-12-14t21:59:43.10-05:00
+---
+name: db-2
+created: 2002-12-14
+---
+- name: db-3
+  port: 0x10
+`
+	cases := []struct {
+		name     string
+		raw      string
+		opts     Options
+		expected []string
+		willFail bool
+	}{
+		{"sequence", sequence, Options{}, []string{
+			"name=web-1 port=8080 ratio=0.5 enabled=yes",
+			"name=web-2 enabled=true owner=<nil> tags=[a b]",
+		}, false},
+		{"documents", documents, Options{}, []string{
+			"name=db-1 created=2001-12-15T02:59:43.1Z",
+			"name=db-2 created=2002-12-14",
+			"name=db-3 port=16",
+		}, false},
+		{"anchors", "- &base {region: eu, size: 2}\n- {<<: *base, size: 3, name: x}\n", Options{}, []string{
+			"region=eu size=2",
+			"size=3 name=x region=eu",
+		}, false},
+		{"binary", "- data: !!binary AQL/\n", Options{BinaryEncoding: HexEncoding}, []string{"data=0102ff"}, false},
+		{"quoted scalars", "- {id: '007', flag: 'true'}\n", Options{}, []string{"id=007 flag=true"}, false},
+		{"empty", "", Options{}, []string{}, false},
+		{"empty document", "---\n---\n- {a: 1}\n", Options{}, []string{"a=1"}, false},
+		{"scalar document", "- {a: 1}\n---\nhello\n", Options{}, []string{"a=1"}, true},
+		{"non-mapping record", "- {a: 1}\n- [1, 2]\n", Options{}, []string{"a=1"}, true},
+		{"invalid syntax", "- {a: 1\n", Options{}, []string{}, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			source := NewYamlSource(strings.NewReader(tc.raw), tc.opts)
+			for pass := 1; pass <= 2; pass++ {
+				found, err := readSource(source)
+				if (err != nil) != tc.willFail {
+					t.Fatalf("expected failure: %t, found: %v", tc.willFail, err)
+				}
+				if strings.Join(found, "\n") != strings.Join(tc.expected, "\n") {
+					t.Logf("pass %d did not match expected records", pass)
+					t.Logf("Expected:\n%s", strings.Join(tc.expected, "\n"))
+					t.Logf("Found:\n%s", strings.Join(found, "\n"))
+					t.FailNow()
+				}
+				if err := source.Reset(); err != nil {
+					t.Fatalf("failed to reset source: %s", err.Error())
+				}
+			}
+		})
+	}
+}